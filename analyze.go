@@ -0,0 +1,447 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+//Slowest operations listed in a report, unless --top says otherwise
+const analyzeTopSlow = 10
+
+//Keys naming the peer of a dial event
+var dialPeerKeys = []string{"peerID", "peer", "remotePeer", "dialTarget"}
+
+//Keys which tell concurrent runs of an operation apart when pairing its begin and end
+var pairKeys = []string{"requestId", "session", "peerID"}
+
+//Spread of the durations of an operation
+type DurationStats struct {
+	Count int           `json:"count"`
+	Min   time.Duration `json:"min"`
+	Mean  time.Duration `json:"mean"`
+	P50   time.Duration `json:"p50"`
+	P90   time.Duration `json:"p90"`
+	P99   time.Duration `json:"p99"`
+	Max   time.Duration `json:"max"`
+}
+
+//Returns nil without durations
+func makeDurationStats(durations []float64) *DurationStats {
+	if len(durations) == 0 {
+		return nil
+	}
+	sorted := append([]float64(nil), durations...)
+	sort.Float64s(sorted)
+	var sum float64
+	for _, d := range sorted {
+		sum += d
+	}
+	at := func(p float64) time.Duration {
+		return time.Duration(sorted[int(p*float64(len(sorted)-1))])
+	}
+	return &DurationStats{
+		Count: len(sorted),
+		Min:   time.Duration(sorted[0]),
+		Mean:  time.Duration(sum / float64(len(sorted))),
+		P50:   at(0.50),
+		P90:   at(0.90),
+		P99:   at(0.99),
+		Max:   time.Duration(sorted[len(sorted)-1]),
+	}
+}
+
+//Events of one system and event name
+type EventStats struct {
+	System    string         `json:"system"`
+	Event     string         `json:"event"`
+	Count     uint64         `json:"count"`
+	Errors    uint64         `json:"errors"`
+	Duration  *DurationStats `json:"duration,omitempty"`
+	durations []float64
+}
+
+type SlowEvent struct {
+	Time     string        `json:"time"`
+	Node     string        `json:"node,omitempty"`
+	System   string        `json:"system"`
+	Event    string        `json:"event"`
+	Duration time.Duration `json:"duration"`
+}
+
+//Outcome of the dials to one peer
+type PeerDials struct {
+	Peer        string  `json:"peer"`
+	Attempts    uint64  `json:"attempts"`
+	Failed      uint64  `json:"failed"`
+	SuccessRate float64 `json:"successRate"`
+}
+
+//How the <operation>Begin and <operation>End events of an operation pair up
+type PairStats struct {
+	System     string         `json:"system"`
+	Operation  string         `json:"operation"`
+	Begun      uint64         `json:"begun"`
+	Ended      uint64         `json:"ended"`
+	OpenBegins uint64         `json:"openBegins"` //begins without an end
+	OrphanEnds uint64         `json:"orphanEnds"` //ends without a begin
+	Duration   *DurationStats `json:"duration,omitempty"`
+	durations  []float64
+}
+
+type AnalyzeReport struct {
+	Files   []string      `json:"files"`
+	Events  uint64        `json:"events"`
+	Errors  uint64        `json:"errors"`
+	Skipped uint64        `json:"skipped"` //lines which are not events
+	First   *time.Time    `json:"first,omitempty"`
+	Last    *time.Time    `json:"last,omitempty"`
+	Systems []*EventStats `json:"systems"`
+	Slowest []SlowEvent   `json:"slowest"`
+	Dials   []*PeerDials  `json:"dials"`
+	Pairs   []*PairStats  `json:"pairs"`
+}
+
+//Begin times of a run of an operation, waiting for their end
+type openBegins struct {
+	stats *PairStats
+	times []time.Time
+}
+
+type analyzer struct {
+	report  AnalyzeReport
+	filters []Filter
+	top     int
+	events  map[string]*EventStats
+	dials   map[string]*PeerDials
+	pairs   map[string]*PairStats
+	open    map[string]*openBegins //by run of an operation
+}
+
+//Read recorded archives or jsonl event logs and report on their events, no daemon needed
+func Analyze(paths []string, filters []Filter, top int) (*AnalyzeReport, error) {
+	a := &analyzer{
+		filters: filters,
+		top:     top,
+		events:  make(map[string]*EventStats),
+		dials:   make(map[string]*PeerDials),
+		pairs:   make(map[string]*PairStats),
+		open:    make(map[string]*openBegins),
+	}
+	for _, path := range paths {
+		if err := a.analyzeFile(path); err != nil {
+			return nil, err
+		}
+	}
+	return a.finish(), nil
+}
+
+func (a *analyzer) analyzeFile(path string) error {
+	rr, err := OpenReplay(path)
+	if err != nil {
+		return err
+	}
+	defer rr.Close()
+	a.report.Files = append(a.report.Files, path)
+	node := ""
+	if rr.Header != nil {
+		node = rr.Header.NodeId
+	}
+	for {
+		ev, err := rr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			//a line which is not an event, the reader moves on to the next
+			var lineErr *ReplayLineError
+			if errors.As(err, &lineErr) {
+				a.report.Skipped++
+				continue
+			}
+			return err
+		}
+		if !PassFilters(a.filters, ev.Event) {
+			continue
+		}
+		a.add(ev.Event, eventNode(ev.Event, node), eventTime(ev))
+	}
+}
+
+//The node an event is tagged with, else the node of the file
+func eventNode(le LogEvent, node string) string {
+	for _, t := range le.Tags {
+		if t.Name == "nodeId" {
+			return t.Value
+		}
+	}
+	return node
+}
+
+//When the event happened, by its own time if it has one
+func eventTime(ev ReplayEvent) time.Time {
+	if !ev.Event.Time.IsZero() {
+		return ev.Event.Time
+	}
+	return ev.Time
+}
+
+func messageString(le LogEvent, key string) string {
+	if v, ok := le.Message[key]; ok && v != nil {
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+func (a *analyzer) add(le LogEvent, node string, ts time.Time) {
+	r := &a.report
+	r.Events++
+	if !ts.IsZero() {
+		if r.First == nil || ts.Before(*r.First) {
+			first := ts
+			r.First = &first
+		}
+		if r.Last == nil || ts.After(*r.Last) {
+			last := ts
+			r.Last = &last
+		}
+	}
+	system := le.System
+	event := le.Event
+	failed := isErrorEvent(le)
+	if failed {
+		r.Errors++
+	}
+
+	key := system + "\x00" + event
+	es := a.events[key]
+	if es == nil {
+		es = &EventStats{System: system, Event: event}
+		a.events[key] = es
+	}
+	es.Count++
+	if failed {
+		es.Errors++
+	}
+	if le.Duration > 0 {
+		es.durations = append(es.durations, float64(le.Duration))
+		a.slow(SlowEvent{
+			Time:     ts.UTC().Format(time.RFC3339Nano),
+			Node:     node,
+			System:   system,
+			Event:    event,
+			Duration: le.Duration,
+		})
+	}
+
+	if strings.HasSuffix(event, "Begin") {
+		a.pair(le, node, system, strings.TrimSuffix(event, "Begin"), true, ts)
+		return
+	}
+	if strings.HasSuffix(event, "End") {
+		a.pair(le, node, system, strings.TrimSuffix(event, "End"), false, ts)
+		return
+	}
+	if strings.Contains(strings.ToLower(event), "dial") {
+		a.dial(le, failed)
+	}
+}
+
+//Keep the slowest operations seen so far
+func (a *analyzer) slow(se SlowEvent) {
+	slowest := a.report.Slowest
+	if a.top <= 0 || len(slowest) >= a.top && se.Duration <= slowest[len(slowest)-1].Duration {
+		return
+	}
+	i := sort.Search(len(slowest), func(i int) bool {
+		return slowest[i].Duration < se.Duration
+	})
+	slowest = append(slowest, SlowEvent{})
+	copy(slowest[i+1:], slowest[i:])
+	slowest[i] = se
+	if len(slowest) > a.top {
+		slowest = slowest[:a.top]
+	}
+	a.report.Slowest = slowest
+}
+
+func (a *analyzer) dial(le LogEvent, failed bool) {
+	peer := ""
+	for _, key := range dialPeerKeys {
+		if peer = messageString(le, key); len(peer) != 0 {
+			break
+		}
+	}
+	if len(peer) == 0 {
+		return
+	}
+	pd := a.dials[peer]
+	if pd == nil {
+		pd = &PeerDials{Peer: peer}
+		a.dials[peer] = pd
+	}
+	pd.Attempts++
+	if failed {
+		pd.Failed++
+	}
+}
+
+//Match an end to the oldest open begin of the same run of the operation
+func (a *analyzer) pair(le LogEvent, node, system, operation string, begin bool, ts time.Time) {
+	key := system + "\x00" + operation
+	ps := a.pairs[key]
+	if ps == nil {
+		ps = &PairStats{System: system, Operation: operation}
+		a.pairs[key] = ps
+	}
+	run := []string{node, key}
+	for _, k := range pairKeys {
+		run = append(run, messageString(le, k))
+	}
+	runKey := strings.Join(run, "\x00")
+	begins := a.open[runKey]
+	if begin {
+		ps.Begun++
+		if begins == nil {
+			begins = &openBegins{stats: ps}
+			a.open[runKey] = begins
+		}
+		begins.times = append(begins.times, ts)
+		return
+	}
+	ps.Ended++
+	if begins == nil {
+		ps.OrphanEnds++
+		return
+	}
+	if !ts.IsZero() && !begins.times[0].IsZero() {
+		ps.durations = append(ps.durations, float64(ts.Sub(begins.times[0])))
+	}
+	if begins.times = begins.times[1:]; len(begins.times) == 0 {
+		delete(a.open, runKey)
+	}
+}
+
+func (a *analyzer) finish() *AnalyzeReport {
+	r := &a.report
+	for _, es := range a.events {
+		es.Duration = makeDurationStats(es.durations)
+		r.Systems = append(r.Systems, es)
+	}
+	sort.Slice(r.Systems, func(i, j int) bool {
+		if r.Systems[i].Count != r.Systems[j].Count {
+			return r.Systems[i].Count > r.Systems[j].Count
+		}
+		if r.Systems[i].System != r.Systems[j].System {
+			return r.Systems[i].System < r.Systems[j].System
+		}
+		return r.Systems[i].Event < r.Systems[j].Event
+	})
+	for _, pd := range a.dials {
+		pd.SuccessRate = float64(pd.Attempts-pd.Failed) / float64(pd.Attempts)
+		r.Dials = append(r.Dials, pd)
+	}
+	sort.Slice(r.Dials, func(i, j int) bool {
+		if r.Dials[i].Failed != r.Dials[j].Failed {
+			return r.Dials[i].Failed > r.Dials[j].Failed
+		}
+		return r.Dials[i].Peer < r.Dials[j].Peer
+	})
+	for _, begins := range a.open {
+		begins.stats.OpenBegins += uint64(len(begins.times))
+	}
+	for _, ps := range a.pairs {
+		ps.Duration = makeDurationStats(ps.durations)
+		r.Pairs = append(r.Pairs, ps)
+	}
+	sort.Slice(r.Pairs, func(i, j int) bool {
+		if r.Pairs[i].System != r.Pairs[j].System {
+			return r.Pairs[i].System < r.Pairs[j].System
+		}
+		return r.Pairs[i].Operation < r.Pairs[j].Operation
+	})
+	return r
+}
+
+func formatDuration(d time.Duration) string {
+	if d >= time.Millisecond {
+		return d.Round(10 * time.Microsecond).String()
+	}
+	return d.String()
+}
+
+//Print a report as tables, one per kind of analysis
+func printAnalyzeReport(w io.Writer, r *AnalyzeReport) {
+	fmt.Fprintf(w, "Files: %s\n", strings.Join(r.Files, ", "))
+	fmt.Fprintf(w, "Events: %d, errors: %d, skipped lines: %d", r.Events, r.Errors, r.Skipped)
+	if r.First != nil {
+		fmt.Fprintf(w, ", from %s to %s (%s)", r.First.UTC().Format(time.RFC3339), r.Last.UTC().Format(time.RFC3339), r.Last.Sub(*r.First))
+	}
+	fmt.Fprint(w, "\n\n")
+
+	durations := func(ds *DurationStats) string {
+		if ds == nil {
+			return "-\t-\t-\t-"
+		}
+		return fmt.Sprintf("%s\t%s\t%s\t%s", formatDuration(ds.P50), formatDuration(ds.P90), formatDuration(ds.P99), formatDuration(ds.Max))
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SYSTEM\tEVENT\tCOUNT\tERRORS\tP50\tP90\tP99\tMAX")
+	for _, es := range r.Systems {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\n", es.System, es.Event, es.Count, es.Errors, durations(es.Duration))
+	}
+	tw.Flush()
+
+	if len(r.Slowest) != 0 {
+		fmt.Fprint(w, "\nSLOWEST\n")
+		tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "TIME\tNODE\tSYSTEM\tEVENT\tDURATION")
+		for _, se := range r.Slowest {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", se.Time, se.Node, se.System, se.Event, formatDuration(se.Duration))
+		}
+		tw.Flush()
+	}
+
+	if r.Errors != 0 {
+		fmt.Fprint(w, "\nERRORS\n")
+		tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "SYSTEM\tEVENT\tERRORS")
+		var errs []*EventStats
+		for _, es := range r.Systems {
+			if es.Errors != 0 {
+				errs = append(errs, es)
+			}
+		}
+		sort.SliceStable(errs, func(i, j int) bool {
+			return errs[i].Errors > errs[j].Errors
+		})
+		for _, es := range errs {
+			fmt.Fprintf(tw, "%s\t%s\t%d\n", es.System, es.Event, es.Errors)
+		}
+		tw.Flush()
+	}
+
+	if len(r.Dials) != 0 {
+		fmt.Fprint(w, "\nDIALS\n")
+		tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "PEER\tATTEMPTS\tFAILED\tSUCCESS")
+		for _, pd := range r.Dials {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f%%\n", pd.Peer, pd.Attempts, pd.Failed, pd.SuccessRate*100)
+		}
+		tw.Flush()
+	}
+
+	if len(r.Pairs) != 0 {
+		fmt.Fprint(w, "\nBEGIN/END\n")
+		tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "SYSTEM\tOPERATION\tBEGUN\tENDED\tOPEN\tORPHAN\tP50\tP90\tP99\tMAX")
+		for _, ps := range r.Pairs {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\t%s\n", ps.System, ps.Operation, ps.Begun, ps.Ended, ps.OpenBegins, ps.OrphanEnds, durations(ps.Duration))
+		}
+		tw.Flush()
+	}
+}
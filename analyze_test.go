@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const analyzeLog = `{"event":"findPeerSingleBegin","system":"dht","time":"2017-11-17T22:09:10Z","requestId":1}
+{"event":"findPeerSingleEnd","system":"dht","time":"2017-11-17T22:09:10.25Z","requestId":1}
+{"event":"findPeerSingleBegin","system":"dht","time":"2017-11-17T22:09:11Z","requestId":2}
+{"event":"findPeerSingleEnd","system":"dht","time":"2017-11-17T22:09:12Z","requestId":3}
+{"event":"swarmDialAttemptSync","system":"swarm2","time":"2017-11-17T22:09:11Z","duration":1129297969,"peerID":"QmA"}
+{"event":"swarmDialAttemptSync","system":"swarm2","time":"2017-11-17T22:09:12Z","duration":2000,"peerID":"QmA","error":"timeout"}
+not json
+{"event":"swarmDialAttemptSync","system":"swarm2","time":"2017-11-17T22:09:13Z","duration":5000,"peerID":"QmB"}
+`
+
+func TestAnalyze(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	if err := ioutil.WriteFile(path, []byte(analyzeLog), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := Analyze([]string{path}, nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Events != 7 || r.Errors != 1 || r.Skipped != 1 {
+		t.Error(fmt.Sprintf("Invalid Totals: events %d errors %d skipped %d", r.Events, r.Errors, r.Skipped))
+	}
+	if len(r.Systems) != 3 || r.Systems[0].Event != "swarmDialAttemptSync" || r.Systems[0].Count != 3 || r.Systems[0].Duration.Max != 1129297969 {
+		t.Error(fmt.Sprintf("Invalid Systems: %v", r.Systems[0]))
+	}
+	if len(r.Slowest) != 2 || r.Slowest[0].Duration != 1129297969 || r.Slowest[1].Duration != 5000 {
+		t.Error(fmt.Sprintf("Invalid Slowest: %v", r.Slowest))
+	}
+	if len(r.Dials) != 2 || r.Dials[0].Peer != "QmA" || r.Dials[0].Attempts != 2 || r.Dials[0].SuccessRate != 0.5 {
+		t.Error(fmt.Sprintf("Invalid Dials: %v", r.Dials[0]))
+	}
+	//requestId 2 never ended, requestId 3 never began
+	if len(r.Pairs) != 1 {
+		t.Fatal(fmt.Sprintf("Invalid Pairs: %v", r.Pairs))
+	}
+	ps := r.Pairs[0]
+	if ps.Operation != "findPeerSingle" || ps.Begun != 2 || ps.Ended != 2 || ps.OpenBegins != 1 || ps.OrphanEnds != 1 || ps.Duration.P50 != 250*time.Millisecond {
+		t.Error(fmt.Sprintf("Invalid Pair: %v %v", ps, ps.Duration))
+	}
+}
+
+func TestAnalyzeFilters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	if err := ioutil.WriteFile(path, []byte(analyzeLog), 0644); err != nil {
+		t.Fatal(err)
+	}
+	filters, err := MakeFilters([]string{"system=dht"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := Analyze([]string{path}, filters, analyzeTopSlow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Events != 4 || len(r.Dials) != 0 || len(r.Slowest) != 0 {
+		t.Error(fmt.Sprintf("Invalid Filtered Report: events %d dials %v slowest %v", r.Events, r.Dials, r.Slowest))
+	}
+}
+
+func TestAnalyzeNoSlowest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	if err := ioutil.WriteFile(path, []byte(analyzeLog), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, top := range []int{0, -1} {
+		r, err := Analyze([]string{path}, nil, top)
+		if err != nil || len(r.Slowest) != 0 {
+			t.Error(fmt.Sprintf("Invalid Slowest with top %d: %v %v", top, r, err))
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+const defaultAPIAddress = "localhost:9123"
+
+//How the cli reaches ipfs-metricsd
+type APIClient struct {
+	Address string //host:port, unix:path, a multiaddr, http://host:port or https://host:port
+	Token   string
+	TLS     *tls.Config //nil for plain http
+
+	once      sync.Once
+	tlsClient *http.Client //built once, so polling commands reuse its connection
+}
+
+var api = &APIClient{Address: defaultAPIAddress}
+
+//Returned when the daemon answers 401, the token is missing or wrong
+var errUnauthorized = errors.New("401 Unauthorized: ipfs-metricsd rejected the api token, check --token or IPFS_METRICS_TOKEN")
+
+//Splits an api address into the network to dial and the address on it
+func parseAPIAddress(addr string) (network, address string, secure bool) {
+	switch {
+	case isMultiaddr(addr):
+		network, address, err := ParseMultiaddr(addr)
+		if err != nil {
+			//let dialing or listening report the bad address
+			return "tcp", addr, false
+		}
+		return network, address, false
+	case strings.HasPrefix(addr, "unix:"):
+		return "unix", strings.TrimPrefix(strings.TrimPrefix(addr, "unix:"), "//"), false
+	case strings.HasPrefix(addr, "https://"):
+		return "tcp", strings.TrimPrefix(addr, "https://"), true
+	case strings.HasPrefix(addr, "http://"):
+		return "tcp", strings.TrimPrefix(addr, "http://"), false
+	}
+	return "tcp", addr, false
+}
+
+func (a *APIClient) client() *http.Client {
+	network, address, _ := parseAPIAddress(a.Address)
+	if a.TLS == nil {
+		return dialClient(network, address)
+	}
+	a.once.Do(func() {
+		transport := &http.Transport{
+			TLSClientConfig: a.TLS,
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, address)
+			},
+		}
+		a.tlsClient = &http.Client{Transport: transport}
+	})
+	return a.tlsClient
+}
+
+//Send a request to ipfs-metricsd
+func (a *APIClient) Do(method, path string, body io.Reader) (*http.Response, error) {
+	network, address, secure := parseAPIAddress(a.Address)
+	scheme := "http"
+	if secure || a.TLS != nil {
+		scheme = "https"
+	}
+	host := address
+	if network == "unix" {
+		//only used for the Host header, the socket is dialed directly
+		host = "ipfs-metricsd"
+	}
+	req, err := http.NewRequest(method, fmt.Sprintf("%s://%s%s", scheme, host, path), body)
+	if err != nil {
+		return nil, err
+	}
+	if len(a.Token) != 0 {
+		req.Header.Set("Authorization", "Bearer "+a.Token)
+	}
+	resp, err := a.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		return nil, errUnauthorized
+	}
+	return resp, nil
+}
+
+//Builds the tls config of the cli, a ca verifies the daemon and
+//a certificate and key authenticate the cli to it
+func clientTLSConfig(ca, cert, key string) (*tls.Config, error) {
+	if len(ca) == 0 && len(cert) == 0 {
+		return nil, nil
+	}
+	config := &tls.Config{}
+	if len(ca) != 0 {
+		pool, err := loadCertPool(ca)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	if len(cert) != 0 {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	return config, nil
+}
+
+//Builds the tls config of the daemon, with a client ca every client
+//must present a certificate signed by it
+func serverTLSConfig(cert, key, clientCA string) (*tls.Config, error) {
+	if len(cert) == 0 {
+		if len(clientCA) != 0 {
+			return nil, errors.New("Client certificates require --tls-cert and --tls-key")
+		}
+		return nil, nil
+	}
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{pair}}
+	if len(clientCA) != 0 {
+		pool, err := loadCertPool(clientCA)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New(fmt.Sprintf("No certificates found in: %s", path))
+	}
+	return pool, nil
+}
+
+//Listen on a tcp or unix socket address, stale unix sockets are removed first
+func listenAPI(addr string) (net.Listener, error) {
+	network, address, _ := parseAPIAddress(addr)
+	if network == "unix" {
+		if _, err := os.Stat(address); err == nil {
+			if _, err := net.Dial("unix", address); err == nil {
+				return nil, errors.New(fmt.Sprintf("Socket in use: %s", address))
+			}
+			os.Remove(address)
+		}
+	}
+	return net.Listen(network, address)
+}
+
+//Reject requests without the bearer token, if one is set
+func requireToken(token string, next http.Handler) http.Handler {
+	if len(token) == 0 {
+		return next
+	}
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(given, expected) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//Returns true if the address only accepts connections from this machine
+func isLocalAddress(addr string) bool {
+	network, address, _ := parseAPIAddress(addr)
+	if network == "unix" {
+		return true
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseAPIAddress(t *testing.T) {
+	addresses := []struct {
+		addr    string
+		network string
+		address string
+		secure  bool
+	}{
+		{"localhost:9123", "tcp", "localhost:9123", false},
+		{"http://127.0.0.1:9123", "tcp", "127.0.0.1:9123", false},
+		{"https://metrics.example:9123", "tcp", "metrics.example:9123", true},
+		{"unix:/run/ipfs-metrics.sock", "unix", "/run/ipfs-metrics.sock", false},
+		{"unix:///run/ipfs-metrics.sock", "unix", "/run/ipfs-metrics.sock", false},
+		{"/ip4/127.0.0.1/tcp/9123", "tcp", "127.0.0.1:9123", false},
+		{"/ip6/::1/tcp/9123", "tcp", "[::1]:9123", false},
+		{"/unix/run/ipfs-metrics.sock", "unix", "/run/ipfs-metrics.sock", false},
+	}
+	for _, a := range addresses {
+		network, address, secure := parseAPIAddress(a.addr)
+		if network != a.network || address != a.address || secure != a.secure {
+			t.Error(fmt.Sprintf("Invalid Address: %s %s %s %v Expected: %s %s %v", a.addr, network, address, secure, a.network, a.address, a.secure))
+		}
+	}
+}
+
+func TestRequireToken(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	requests := []struct {
+		token         string
+		authorization string
+		status        int
+	}{
+		{"", "", http.StatusOK},
+		{"", "Bearer other", http.StatusOK},
+		{"secret", "Bearer secret", http.StatusOK},
+		{"secret", "", http.StatusUnauthorized},
+		{"secret", "Bearer other", http.StatusUnauthorized},
+		{"secret", "secret", http.StatusUnauthorized},
+		{"secret", "Bearer secret2", http.StatusUnauthorized},
+	}
+	for _, r := range requests {
+		req := httptest.NewRequest("PUT", "/", strings.NewReader("{}"))
+		if len(r.authorization) != 0 {
+			req.Header.Set("Authorization", r.authorization)
+		}
+		w := httptest.NewRecorder()
+		requireToken(r.token, ok).ServeHTTP(w, req)
+		if w.Code != r.status {
+			t.Error(fmt.Sprintf("Invalid Status: %d for token %q authorization %q Expected: %d", w.Code, r.token, r.authorization, r.status))
+		}
+	}
+}
+
+func TestTLSClientReused(t *testing.T) {
+	client := &APIClient{Address: "https://metrics.example:9123", TLS: &tls.Config{}}
+	if client.client() != client.client() {
+		t.Error("TLS client built for every request")
+	}
+}
+
+func TestUnauthorizedClient(t *testing.T) {
+	server := httptest.NewServer(requireToken("secret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	defer server.Close()
+	client := &APIClient{Address: server.URL, Token: "other"}
+	if _, err := client.Do("PUT", "/", nil); err != errUnauthorized {
+		t.Error(fmt.Sprintf("Invalid Error: %v Expected: %v", err, errUnauthorized))
+	}
+	client.Token = "secret"
+	resp, err := client.Do("PUT", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+//What a proxy does when the next stage of its pipeline can not keep up
+const (
+	BackpressureBlock      = "block"       //wait, which eventually stalls the source
+	BackpressureDropNewest = "drop-newest" //drop the event being sent
+	BackpressureDropOldest = "drop-oldest" //drop the oldest queued event to make room
+	BackpressureSpill      = "spill"       //queue the event on disk until there is room
+)
+
+const defaultBufferSize = 64
+
+func validBackpressure(policy string) bool {
+	switch policy {
+	case "", BackpressureBlock, BackpressureDropNewest, BackpressureDropOldest, BackpressureSpill:
+		return true
+	}
+	return false
+}
+
+func (s Source) bufferSize() int {
+	if s.BufferSize < 1 {
+		return defaultBufferSize
+	}
+	return s.BufferSize
+}
+
+//Create the spill queues of a proxy using the spill policy
+func (lp *LogProxy) openSpill() error {
+	if lp.Source.Backpressure != BackpressureSpill {
+		return nil
+	}
+	var err error
+	lp.inSpill, err = newSpillQueue(lp.Source.SpillDir, fmt.Sprintf("ipfs-metrics-%s-inbound-", lp.Name))
+	if err != nil {
+		return err
+	}
+	lp.outSpill, err = newSpillQueue(lp.Source.SpillDir, fmt.Sprintf("ipfs-metrics-%s-outbound-", lp.Name))
+	if err != nil {
+		lp.inSpill.Close()
+		return err
+	}
+	go lp.inSpill.drain(lp.ctx, lp.Inbound)
+	go lp.outSpill.drain(lp.ctx, lp.Outbound)
+	return nil
+}
+
+//Send an event to the next stage following the backpressure policy of the source,
+//updates are never dropped
+func (lp *LogProxy) push(ch chan LogEvent, spill *spillQueue, event LogEvent) {
+	//once something is spilled everything after it must be too, to keep the order
+	if spill != nil && spill.Len() != 0 {
+		lp.spill(ch, spill, event)
+		return
+	}
+	select {
+	case ch <- event:
+		return
+	case <-lp.ctx.Done():
+		return
+	default:
+	}
+
+	switch lp.Source.Backpressure {
+	case BackpressureDropNewest:
+		if event.update == nil {
+			lp.Stats.queueDrop()
+			return
+		}
+	case BackpressureDropOldest:
+		//while an update is in flight the oldest event may be the update,
+		//which would keep going to the back of the queue, so drop the newest
+		if atomic.LoadInt32(&lp.updating) != 0 && event.update == nil {
+			lp.Stats.queueDrop()
+			return
+		}
+		select {
+		case old := <-ch:
+			if old.update != nil {
+				lp.send(ch, old)
+				break
+			}
+			lp.Stats.queueDrop()
+		default:
+		}
+	case BackpressureSpill:
+		if spill != nil {
+			lp.spill(ch, spill, event)
+			return
+		}
+	}
+	lp.send(ch, event)
+}
+
+//Block until the event is sent or the proxy closed
+func (lp *LogProxy) send(ch chan LogEvent, event LogEvent) {
+	select {
+	case ch <- event:
+	case <-lp.ctx.Done():
+	}
+}
+
+func (lp *LogProxy) spill(ch chan LogEvent, spill *spillQueue, event LogEvent) {
+	if err := spill.Push(event); err != nil {
+		errlog.Printf("Spill Source: %s error: %v", lp.source(), err)
+		if event.update != nil {
+			lp.send(ch, event)
+			return
+		}
+		lp.Stats.queueDrop()
+		return
+	}
+	lp.Stats.spilled()
+}
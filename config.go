@@ -1,10 +1,10 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"net"
+	"net/http"
 	"strings"
 
 	cli "github.com/codegangsta/cli"
@@ -16,59 +16,107 @@ type Tag struct {
 }
 
 type Source struct {
-	Address string `json:"Address"`
-	Port    string `json:"Port"`
-	Tags    []Tag  `json:"Tags"`
+	Address        string            `json:"Address"`
+	Port           string            `json:"Port"`
+	Tags           []Tag             `json:"Tags"`
+	Filters        []Filter          `json:"Filters"`
+	BufferSize     int               `json:"BufferSize"`     //size of the Inbound and Outbound channels, 64 if unset
+	Backpressure   string            `json:"Backpressure"`   //block, drop-newest, drop-oldest or spill
+	SpillDir       string            `json:"SpillDir"`       //where spilled events are kept, the temp dir if unset
+	PollInterval   string            `json:"PollInterval"`   //how often the stats endpoints are polled, never if unset
+	Type           string            `json:"Type"`           //eventlog, the default, prometheus or replay
+	ScrapeInterval string            `json:"ScrapeInterval"` //how often a prometheus source is scraped, 15s if unset
+	MetricsPath    string            `json:"MetricsPath"`    //path of the prometheus metrics, /debug/metrics/prometheus if unset
+	Record         string            `json:"Record"`         //archive the raw events are recorded to, on the daemon's disk
+	File           string            `json:"File"`           //archive or jsonl of events a replay source reads, on the daemon's disk
+	Speed          string            `json:"Speed"`          //multiplier of the pace of a replay, 1 if unset, or max
+	RewriteTime    bool              `json:"RewriteTime"`    //shift the times of replayed events so the replay starts now
+	ClockSkew      string            `json:"ClockSkew"`      //estimate, the default, annotate or correct the clock offset of the node
+	LogFormat      string            `json:"LogFormat"`      //auto, the default, eventlog or zap, the format of the event log of the node
+	LogLevels      map[string]string `json:"LogLevels"`      //levels set on the subsystems of the node when it is connected, e.g. dht: debug
 }
 type Sink struct {
-	Address string `json:"Address"`
-	Port    string `json:"Port"`
-	Format  string `json:"Format"`
+	Address   string   `json:"Address"`
+	Port      string   `json:"Port"`
+	Format    string   `json:"Format"`
+	BatchSize int      `json:"BatchSize"` //events per write, 1 if unset
+	Username  string   `json:"Username"`  //influxdb credentials, if it requires auth
+	Password  string   `json:"Password"`
+	File      string   `json:"File"`    //file written instead of stdout or influxdb, on the daemon's disk
+	Flatten   *Flatten `json:"Flatten"` //nested objects and arrays of the message become line protocol fields
 }
 
-type Config struct {
-	Source []Source `json:"Source"`
-	Sink   Sink     `json:"Sink"`
+//How the nested objects and arrays of a message become line protocol fields
+type Flatten struct {
+	Separator      string `json:"Separator"`      //between the keys of nested objects, . if unset
+	Arrays         string `json:"Arrays"`         //join, the default, or explode into a field per element
+	ArraySeparator string `json:"ArraySeparator"` //between the joined elements of an array, , if unset
 }
 
-func (s Sink) String() string {
-	return fmt.Sprintf("%s:%s", s.Address, s.Port)
+//Finds the sources of a network instead of adding each by hand
+type Discovery struct {
+	Seed     string   `json:"Seed"`     //api of a node whose swarm peers are probed, host:port or a multiaddr
+	APIPort  string   `json:"APIPort"`  //api port probed on the peers of the seed, 5001 if unset
+	Static   []string `json:"Static"`   //api addresses, host:port or multiaddrs
+	SRV      string   `json:"SRV"`      //dns name with SRV records of apis, e.g. _ipfs-api._tcp.testnet.example
+	Repos    string   `json:"Repos"`    //glob of ipfs repo directories, the api files in them are read
+	Interval string   `json:"Interval"` //time between discovery rounds, 30s if unset
+	MaxNodes int      `json:"MaxNodes"` //most sources discovery adds, unlimited if 0
+	Source   Source   `json:"Source"`   //tags, filters and buffers every discovered source inherits
 }
-func (s Source) String() string {
-	return fmt.Sprintf("%s:%s", s.Address, s.Port)
+
+//Joins the events of every source in the collection which share the value of a key
+type Correlation struct {
+	Window   string   `json:"Window"`   //how long an operation may go without events before it is written, 5s if unset
+	Keys     []string `json:"Keys"`     //event fields joining events, requestId, session and peerID if unset
+	MinNodes int      `json:"MinNodes"` //nodes an operation must span to be written, 2 if unset
 }
-func (t Tag) String() string {
-	return fmt.Sprintf("%s=%s", t.Name, t.Value)
+
+type Config struct {
+	Source      []Source     `json:"Source"`
+	Sink        Sink         `json:"Sink"`
+	Discovery   *Discovery   `json:"Discovery,omitempty"`
+	Correlation *Correlation `json:"Correlation,omitempty"`
 }
 
-//return nil if valid, error if else
-func ValidConfig(config *Config) error {
-	if !validSources(config.Source) {
-		return errors.New("Invalid config, no source specified")
-	}
-	if len(config.Sink.Address) != 0 && len(config.Sink.Port) == 0 {
-		return errors.New("invalid config, no sink port given")
+func (s Sink) String() string {
+	if len(s.File) != 0 {
+		return s.File
 	}
-	if len(config.Sink.Address) == 0 && len(config.Sink.Port) != 0 {
-		return errors.New("invalid config, no sink address given")
+	return net.JoinHostPort(s.Address, s.Port)
+}
+
+//Authenticate a request to influxdb, if the sink has credentials
+func (s Sink) setAuth(r *http.Request) {
+	if len(s.Username) != 0 {
+		r.SetBasicAuth(s.Username, s.Password)
 	}
-	if len(config.Sink.Format) == 0 {
-		return errors.New("invalid config, no sink format given")
+}
+
+//Copy of the sink which can be shown to clients
+func (s Sink) redacted() Sink {
+	if len(s.Password) != 0 {
+		s.Password = "********"
 	}
-	format := strings.ToLower(config.Sink.Format)
-	if !(format == "json" || format == "lineprotocol") {
-		return errors.New(fmt.Sprintf("invalid config, unknown format: %s", format))
+	return s
+}
+func (s Sink) batchSize() int {
+	if s.BatchSize < 1 {
+		return 1
 	}
-	return nil
+	return s.BatchSize
 }
-
-func validSources(sources []Source) bool {
-	for s := range sources {
-		if len(sources[s].Address) == 0 || len(sources[s].Port) == 0 {
-			return false
-		}
+func (s Source) String() string {
+	if s.isReplay() {
+		return s.File
 	}
-	return true
+	if isMultiaddr(s.Address) {
+		return s.Address
+	}
+	return net.JoinHostPort(s.Address, s.Port)
+}
+func (t Tag) String() string {
+	return fmt.Sprintf("%s=%s", t.Name, t.Value)
 }
 
 func MakeSink(format, address, port string) *Sink {
@@ -127,65 +175,110 @@ func MakeTags(tags []string) ([]Tag, error) {
 	return ts, nil
 }
 
-func LoadConfigFromFile(path string) (*Config, error) {
-	file, err := ioutil.ReadFile(path)
+func LoadConfigFromArgs(c *cli.Context) (*Config, error) {
+	var config Config
+	input := c.String("input")
+	if len(input) == 0 {
+		//fall back to the daemon of the local ipfs repo
+		ma, err := ReadRepoAPI(c.String("repo"))
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Input of event logs required: %v", err))
+		}
+		infolog.Printf("No input given, using the api of the ipfs repo: %s\n", ma)
+		input = ma
+	}
+	address, port, err := ParseInput(input)
 	if err != nil {
 		return nil, err
 	}
-
-	var config Config
-	err = json.Unmarshal(file, &config)
+	tags, err := MakeTags(c.Args())
 	if err != nil {
 		return nil, err
 	}
-	return &config, nil
-}
-
-func LoadConfigFromArgs(c *cli.Context) (*Config, error) {
-	var config Config
-	if len(c.String("input")) == 0 {
-		return nil, errors.New("Input of event logs required")
-	}
-	input := strings.Split(c.String("input"), ":")
-	if len(input) != 2 {
-		return nil, errors.New("Input format invalid")
-	}
-	tags, err := MakeTags(c.Args())
+	filters, err := MakeFilters(c.StringSlice("filter"))
 	if err != nil {
 		return nil, err
 	}
 	source := Source{
-		Address: input[0],
-		Port:    input[1],
-		Tags:    tags,
+		Address:      address,
+		Port:         port,
+		Tags:         tags,
+		Filters:      filters,
+		BufferSize:   c.Int("buffer-size"),
+		Backpressure: c.String("backpressure"),
+		SpillDir:     c.String("spill-dir"),
+		PollInterval: pollIntervalFlag(c),
+		Record:       c.String("record"),
+		ClockSkew:    c.String("clock-skew"),
+		LogFormat:    c.String("log-format"),
+	}
+	if source.LogLevels, err = ParseLogLevels(c.StringSlice("log-level")); err != nil {
+		return nil, err
+	}
+	if c.Bool("prometheus") {
+		source.Type = SourcePrometheus
+		source.ScrapeInterval = c.Duration("scrape-interval").String()
 	}
 	config.Source = append(config.Source, source)
 
+	sink, err := SinkFromArgs(c)
+	if err != nil {
+		return nil, err
+	}
+	config.Sink = *sink
+	return &config, nil
+}
+
+//Sink of the --output, --lineprotocol and --batch-size flags
+func SinkFromArgs(c *cli.Context) (*Sink, error) {
 	var format string
+	flatten := flattenFromArgs(c)
 	if c.Bool("lineprotocol") {
 		format = "lineprotocol"
 	} else {
 		format = "json"
 	}
+	if trace := c.String("trace"); len(trace) != 0 {
+		if len(c.String("output")) != 0 {
+			return nil, errors.New("Trace is written to a file, not an output")
+		}
+		return &Sink{Format: "trace", File: trace, BatchSize: c.Int("batch-size")}, nil
+	}
 
 	//Since sink is an optionl field
 	var sink Sink
 	if len(c.String("output")) == 0 {
 		infolog.Println("No output given, will write to stdout")
 		sink = Sink{
-			Format: format,
+			Format:    format,
+			BatchSize: c.Int("batch-size"),
+			Flatten:   flatten,
 		}
 	} else {
-		output := strings.Split(c.String("output"), ":")
-		if len(input) != 2 {
-			return nil, errors.New("Output format invalid")
+		address, port, err := ParseOutput(c.String("output"))
+		if err != nil {
+			return nil, err
 		}
 		sink = Sink{
-			Address: output[0],
-			Port:    output[1],
-			Format:  format,
+			Address:   address,
+			Port:      port,
+			Format:    format,
+			BatchSize: c.Int("batch-size"),
+			Flatten:   flatten,
 		}
 	}
-	config.Sink = sink
-	return &config, nil
+	return &sink, nil
+}
+
+//Flattening is on with --flatten or any of its options
+func flattenFromArgs(c *cli.Context) *Flatten {
+	f := Flatten{
+		Separator:      c.String("separator"),
+		Arrays:         c.String("arrays"),
+		ArraySeparator: c.String("array-separator"),
+	}
+	if !c.Bool("flatten") && f == (Flatten{}) {
+		return nil
+	}
+	return &f
 }
@@ -2,6 +2,9 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -62,3 +65,228 @@ func TestMakeTags(t *testing.T) {
 		}
 	}
 }
+
+func TestExpandEnv(t *testing.T) {
+	os.Setenv("IPFS_METRICS_TEST_SET", "secret")
+	os.Setenv("IPFS_METRICS_TEST_EMPTY", "")
+	expansions := map[string]string{
+		"${IPFS_METRICS_TEST_SET}":                "secret",
+		"${IPFS_METRICS_TEST_UNSET:-8086}":        "8086",
+		"${IPFS_METRICS_TEST_EMPTY:-8086}":        "8086",
+		"${IPFS_METRICS_TEST_EMPTY}":              "",
+		"${IPFS_METRICS_TEST_SET:-other}:$HOME":   "secret:$HOME",
+		"a${IPFS_METRICS_TEST_SET}b${UNSET_X:-c}": "asecretbc",
+		"$${IPFS_METRICS_TEST_SET}":               "${IPFS_METRICS_TEST_SET}",
+	}
+	for text, expected := range expansions {
+		expanded, err := expandEnv(text)
+		if err != nil || expanded != expected {
+			t.Error(fmt.Sprintf("Invalid Expansion: %s %s %v Expected: %s", text, expanded, err, expected))
+		}
+	}
+	if _, err := expandEnv("${IPFS_METRICS_TEST_UNSET}"); err == nil {
+		t.Error("Expansion of an unset variable should fail")
+	}
+}
+
+func TestLoadConfigFormats(t *testing.T) {
+	dir := t.TempDir()
+	os.Setenv("IPFS_METRICS_TEST_PASSWORD", "hun\"ter\n2")
+	files := map[string]string{
+		"base.json": `{"Source": [{"Address": "127.0.0.1", "Port": "5001"}], "Sink": {"Address": "127.0.0.2", "Port": "8086", "Format": "json"}}`,
+		"config.yaml": `
+include: base.json
+source:
+  - address: 127.0.0.3
+    port: "5001"
+    tags:
+      - {name: env, value: prod}
+sink:
+  format: lineprotocol
+  username: metrics
+  password: ${IPFS_METRICS_TEST_PASSWORD}
+`,
+		"base.toml": `
+[[Source]]
+Address = "127.0.0.1"
+Port = "5001"
+
+[Sink]
+Address = "127.0.0.2"
+Port = "8086"
+Format = "json"
+`,
+		"include.toml": `
+Include = "base.toml"
+
+[[Source]]
+Address = "127.0.0.3"
+Port = "5001"
+
+[Sink]
+Format = "lineprotocol"
+Username = "metrics"
+Password = "${IPFS_METRICS_TEST_PASSWORD}"
+`,
+		"config.toml": `
+Include = ["base.json"]
+
+[[Source]]
+Address = "127.0.0.3"
+Port = "${IPFS_METRICS_TEST_PORT:-5001}"
+
+[Sink]
+Format = "lineprotocol"
+Username = "metrics"
+Password = "${IPFS_METRICS_TEST_PASSWORD}"
+`,
+	}
+	for name, text := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"config.yaml", "config.toml", "include.toml"} {
+		config, err := LoadConfigFromFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Error(fmt.Sprintf("Failed to load %s: %v", name, err))
+			continue
+		}
+		if len(config.Source) != 2 || config.Source[0].Address != "127.0.0.1" || config.Source[1].Address != "127.0.0.3" || config.Source[1].Port != "5001" {
+			t.Error(fmt.Sprintf("Invalid Sources of %s: %v", name, config.Source))
+		}
+		sink := Sink{Address: "127.0.0.2", Port: "8086", Format: "lineprotocol", Username: "metrics", Password: "hun\"ter\n2"}
+		if config.Sink != sink {
+			t.Error(fmt.Sprintf("Invalid Sink of %s: %v Expected: %v", name, config.Sink, sink))
+		}
+		if err := ValidConfig(config); err != nil {
+			t.Error(fmt.Sprintf("Config %s is valid: %v", name, err))
+		}
+	}
+}
+
+//Unquoted ports are numbers and numbers from environment variables are strings
+func TestLoadConfigScalars(t *testing.T) {
+	dir := t.TempDir()
+	os.Setenv("IPFS_METRICS_TEST_BATCH", "64")
+	files := map[string]string{
+		"scalars.yaml": `
+source:
+  - address: 127.0.0.1
+    port: 5001
+    buffersize: ${IPFS_METRICS_TEST_BATCH}
+    tags:
+      - {name: shard, value: 3}
+sink:
+  address: 127.0.0.2
+  port: 8086
+  format: json
+  batchsize: ${IPFS_METRICS_TEST_BATCH}
+`,
+		"scalars.toml": `
+[[Source]]
+Address = "127.0.0.1"
+Port = 5001
+BufferSize = "${IPFS_METRICS_TEST_BATCH}"
+Tags = [{Name = "shard", Value = 3}]
+
+[Sink]
+Address = "127.0.0.2"
+Port = 8086
+Format = "json"
+BatchSize = "${IPFS_METRICS_TEST_BATCH}"
+`,
+	}
+	for name, text := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		config, err := LoadConfigFromFile(path)
+		if err != nil {
+			t.Error(fmt.Sprintf("Failed to load %s: %v", name, err))
+			continue
+		}
+		source := config.Source[0]
+		if source.Port != "5001" || source.BufferSize != 64 || len(source.Tags) != 1 || source.Tags[0].Value != "3" {
+			t.Error(fmt.Sprintf("Invalid Source of %s: %+v", name, source))
+		}
+		if config.Sink.Port != "8086" || config.Sink.BatchSize != 64 {
+			t.Error(fmt.Sprintf("Invalid Sink of %s: %+v", name, config.Sink))
+		}
+	}
+	//a value which is no number is still reported
+	path := filepath.Join(dir, "invalid.yaml")
+	ioutil.WriteFile(path, []byte("sink:\n  batchsize: many\n"), 0644)
+	if _, err := LoadConfigFromFile(path); err == nil {
+		t.Error("Loaded a batch size which is no number")
+	}
+}
+
+func TestIncludeCycle(t *testing.T) {
+	dir := t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir, "a.yaml"), []byte("include: b.yaml\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "b.yaml"), []byte("include: a.yaml\n"), 0644)
+	if _, err := LoadConfigFromFile(filepath.Join(dir, "a.yaml")); err == nil {
+		t.Error("Include cycle should fail")
+	}
+}
+
+func TestValidConfigErrors(t *testing.T) {
+	config := &Config{
+		Source: []Source{
+			{Address: "127.0.0.1", Port: "5001"},
+			{Address: "127.0.0.1", Port: "5001"},
+			{Address: "bad_host", Port: "70000", Tags: []Tag{{Name: "a,b", Value: "c"}}},
+			{Address: "/ip4/127.0.0.1/udp/5001"},
+			{Address: "127.0.0.1", Backpressure: "sometimes"},
+		},
+		Sink: Sink{Address: "127.0.0.2", Format: "xml", BatchSize: -1, Flatten: &Flatten{Arrays: "split"}},
+	}
+	expected := []string{
+		"Source[1]",
+		"Source[2].Address",
+		"Source[2].Port",
+		"Source[2].Tags[0]",
+		"Source[3].Address",
+		"Source[4].Port",
+		"Source[4].Backpressure",
+		"Sink.Port",
+		"Sink.Format",
+		"Sink.BatchSize",
+		"Sink.Flatten.Arrays",
+		"Sink.Flatten",
+	}
+	errs, ok := ValidConfig(config).(ConfigErrors)
+	if !ok || len(errs) != len(expected) {
+		t.Fatal(fmt.Sprintf("Invalid Errors: %v Expected: %v", errs, expected))
+	}
+	for i, err := range errs {
+		if err.Path != expected[i] {
+			t.Error(fmt.Sprintf("Invalid Error Path: %s Expected: %s", err.Path, expected[i]))
+		}
+	}
+	if ValidConfig(&Config{Source: config.Source[:1], Sink: Sink{Format: "json"}}) != nil {
+		t.Error("Config is valid")
+	}
+}
+
+func TestUnknownKeys(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	text := `
+source:
+  - address: 127.0.0.1
+    port: "5001"
+    bufer_size: 10
+sink:
+  format: json
+  adress: 127.0.0.2
+`
+	ioutil.WriteFile(path, []byte(text), 0644)
+	_, err := ValidateConfigFile(path)
+	errs, ok := err.(ConfigErrors)
+	if !ok || len(errs) != 2 || errs[0].Path != "Sink.adress" || errs[1].Path != "Source[0].bufer_size" {
+		t.Error(fmt.Sprintf("Invalid Errors: %v", err))
+	}
+}
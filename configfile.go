@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+//${VAR} or ${VAR:-default}, $${ is a literal ${
+var envPattern = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+//Substitute environment variables into a string value of a config file,
+//a variable without a default must be set
+func expandEnv(text string) (string, error) {
+	var missing []string
+	expanded := envPattern.ReplaceAllStringFunc(text, func(ref string) string {
+		if ref == "$${" {
+			return "${"
+		}
+		m := envPattern.FindStringSubmatch(ref)
+		if v, ok := os.LookupEnv(m[1]); ok && (len(v) != 0 || len(m[2]) == 0) {
+			return v
+		}
+		if len(m[2]) != 0 {
+			return m[3]
+		}
+		missing = append(missing, m[1])
+		return ""
+	})
+	if len(missing) != 0 {
+		return "", errors.New(fmt.Sprintf("Config uses unset environment variables: %s", strings.Join(missing, ", ")))
+	}
+	return expanded, nil
+}
+
+//Substitute environment variables into the string values of a parsed config,
+//so a value can not change the syntax of the file around it
+func expandConfigTree(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return expandEnv(v)
+	case map[string]interface{}:
+		for key, elem := range v {
+			expanded, err := expandConfigTree(elem)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = expanded
+		}
+	case []map[string]interface{}:
+		for _, elem := range v {
+			if _, err := expandConfigTree(elem); err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		for i, elem := range v {
+			expanded, err := expandConfigTree(elem)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = expanded
+		}
+	}
+	return value, nil
+}
+
+//Parse a config file into a generic tree, the format is picked by the extension
+func parseConfigTree(path string, text []byte) (map[string]interface{}, error) {
+	tree := make(map[string]interface{})
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(text, &tree)
+	case ".toml":
+		_, err = toml.Decode(string(text), &tree)
+	default:
+		err = json.Unmarshal(text, &tree)
+	}
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Config %s: %v", path, err))
+	}
+	return tree, nil
+}
+
+//Read a config file and the files it includes, merged into one tree
+func loadConfigTree(path string, seen map[string]bool) (map[string]interface{}, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if seen[abs] {
+		return nil, errors.New(fmt.Sprintf("Config %s includes itself", path))
+	}
+	seen[abs] = true
+	defer delete(seen, abs)
+
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := parseConfigTree(path, file)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := expandConfigTree(tree); err != nil {
+		return nil, errors.New(fmt.Sprintf("Config %s: %v", path, err))
+	}
+
+	includes, err := configIncludes(path, tree)
+	if err != nil {
+		return nil, err
+	}
+	merged := make(map[string]interface{})
+	for _, include := range includes {
+		sub, err := loadConfigTree(include, seen)
+		if err != nil {
+			return nil, err
+		}
+		mergeConfigTree(merged, sub)
+	}
+	mergeConfigTree(merged, tree)
+	return merged, nil
+}
+
+//Returns the files a config includes, relative paths are relative to the config
+func configIncludes(path string, tree map[string]interface{}) ([]string, error) {
+	var paths []string
+	for key, value := range tree {
+		if !strings.EqualFold(key, "Include") {
+			continue
+		}
+		delete(tree, key)
+		switch v := value.(type) {
+		case string:
+			paths = append(paths, v)
+		case []interface{}:
+			for _, p := range v {
+				s, ok := p.(string)
+				if !ok {
+					return nil, errors.New(fmt.Sprintf("Config %s: Include must be a list of paths", path))
+				}
+				paths = append(paths, s)
+			}
+		default:
+			return nil, errors.New(fmt.Sprintf("Config %s: Include must be a path or a list of paths", path))
+		}
+	}
+	for i, p := range paths {
+		if !filepath.IsAbs(p) {
+			paths[i] = filepath.Join(filepath.Dir(path), p)
+		}
+	}
+	return paths, nil
+}
+
+//Merge src into dst: lists are appended, tables merged and other values replaced.
+//Keys are matched without case like encoding/json does
+func mergeConfigTree(dst, src map[string]interface{}) {
+	for key, value := range src {
+		existing := key
+		for k := range dst {
+			if strings.EqualFold(k, key) {
+				existing = k
+			}
+		}
+		old, ok := dst[existing]
+		if !ok {
+			dst[key] = value
+			continue
+		}
+		if v, ok := configList(value); ok {
+			if o, ok := configList(old); ok {
+				dst[existing] = append(o, v...)
+				continue
+			}
+		}
+		if v, ok := value.(map[string]interface{}); ok {
+			if o, ok := old.(map[string]interface{}); ok {
+				mergeConfigTree(o, v)
+				continue
+			}
+		}
+		dst[existing] = value
+	}
+}
+
+//Returns a list of a parsed config as []interface{}, toml decodes
+//a list of tables like [[Source]] as []map[string]interface{}
+func configList(value interface{}) ([]interface{}, bool) {
+	switch v := value.(type) {
+	case []interface{}:
+		return v, true
+	case []map[string]interface{}:
+		list := make([]interface{}, 0, len(v))
+		for _, m := range v {
+			list = append(list, m)
+		}
+		return list, true
+	}
+	return nil, false
+}
+
+//Load a json, yaml or toml config, after expanding environment variables and includes
+func LoadConfigFromFile(path string) (*Config, error) {
+	tree, err := loadConfigTree(path, make(map[string]bool))
+	if err != nil {
+		return nil, err
+	}
+	config, err := decodeConfigTree(tree)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Config %s: %v", path, err))
+	}
+	return config, nil
+}
+
+//The tree is decoded like a json config, so keys are the same in every format
+func decodeConfigTree(tree map[string]interface{}) (*Config, error) {
+	coerceConfigTree(tree, ConfigSchema())
+	b, err := json.Marshal(tree)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+//Convert scalars to the type of their field: yaml and toml write an unquoted
+//port: 5001 as a number, and a number from an environment variable is a string
+func coerceConfigTree(value interface{}, schema map[string]interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if values, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+			for key, elem := range v {
+				v[key] = coerceConfigTree(elem, values)
+			}
+			return v
+		}
+		props, _ := schema["properties"].(map[string]interface{})
+		for key, elem := range v {
+			if _, prop := schemaProperty(props, key); prop != nil {
+				v[key] = coerceConfigTree(elem, prop)
+			}
+		}
+		return v
+	case []interface{}, []map[string]interface{}:
+		list, _ := configList(v)
+		items, _ := schema["items"].(map[string]interface{})
+		if items == nil {
+			return v
+		}
+		for i, elem := range list {
+			list[i] = coerceConfigTree(elem, items)
+		}
+		return list
+	case string:
+		switch schema["type"] {
+		case "integer":
+			if n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+				return n
+			}
+		case "boolean":
+			if b, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+				return b
+			}
+		}
+	case int, int64, uint64, float64, bool:
+		if schema["type"] == "string" {
+			return fmt.Sprint(v)
+		}
+	}
+	return value
+}
+
+//Allowed values of config fields, by struct and field name
+var configEnums = map[string][]string{
+	"Sink.Format":         {"json", "lineprotocol", "trace"},
+	"Flatten.Arrays":      {FlattenJoin, FlattenExplode},
+	"Source.Backpressure": {BackpressureBlock, BackpressureDropNewest, BackpressureDropOldest, BackpressureSpill},
+	"Source.Type":         {SourceEventLog, SourcePrometheus, SourceReplay},
+	"Source.ClockSkew":    {ClockSkewEstimate, ClockSkewAnnotate, ClockSkewCorrect},
+	"Source.LogFormat":    {LogFormatAuto, LogFormatEventLog, LogFormatZap},
+}
+
+//Returns the JSON Schema of a config file
+func ConfigSchema() map[string]interface{} {
+	schema := typeSchema(reflect.TypeOf(Config{}))
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "ipfs-metrics config"
+	props := schema["properties"].(map[string]interface{})
+	props["Include"] = map[string]interface{}{
+		"description": "Config files merged into this one, relative to it",
+		"oneOf": []interface{}{
+			map[string]interface{}{"type": "string"},
+			map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+		},
+	}
+	schema["required"] = []string{"Sink"}
+	return schema
+}
+
+func typeSchema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Struct:
+		props := make(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := strings.Split(f.Tag.Get("json"), ",")[0]
+			if len(f.PkgPath) != 0 || name == "-" {
+				continue
+			}
+			if len(name) == 0 {
+				name = f.Name
+			}
+			prop := typeSchema(f.Type)
+			if enum, ok := configEnums[t.Name()+"."+f.Name]; ok {
+				prop["enum"] = enum
+			}
+			props[name] = prop
+		}
+		return map[string]interface{}{
+			"type":       "object",
+			"properties": props,
+		}
+	case reflect.Ptr:
+		return typeSchema(t.Elem())
+	case reflect.Slice:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": typeSchema(t.Elem()),
+		}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": typeSchema(t.Elem()),
+		}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int64, reflect.Int32, reflect.Uint, reflect.Uint64, reflect.Uint32:
+		return map[string]interface{}{"type": "integer"}
+	}
+	return map[string]interface{}{"type": "string"}
+}
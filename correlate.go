@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultCorrelationWindow   = 5 * time.Second
+	minCorrelationWindow       = 100 * time.Millisecond
+	defaultCorrelationMinNodes = 2
+	//measurement of the events made from correlated operations
+	correlationMeasurement = "ipfs_correlation"
+	//events kept of one operation, the ones after are only counted
+	correlationMaxEvents = 1000
+)
+
+var defaultCorrelationKeys = []string{"requestId", "session", "peerID"}
+
+//Request ids count up on each node, so they only join events which also share
+//a session or connect the same pair of nodes
+const correlationRequestKey = "requestId"
+
+//A step of a correlated operation
+type CorrelatedEvent struct {
+	Node     string        `json:"node"`
+	System   string        `json:"system"`
+	Event    string        `json:"event"`
+	Time     time.Time     `json:"time"`
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+//The events sharing the value of a key, while their times are within the window of each other
+type correlationGroup struct {
+	key     string
+	value   string
+	events  []CorrelatedEvent
+	dropped int
+	nodes   map[string]bool
+	first   time.Time //times of the events, corrected for the clock of their node
+	last    time.Time
+	seen    time.Time //when the last event arrived, the group is written once it is quiet
+}
+
+//Joins events across the collection, not per proxy, so an operation
+//which spreads from node to node is written as one event
+type correlator struct {
+	Correlation
+	sink     Sink
+	window   time.Duration
+	peerKeys map[string]bool
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+	wake     chan struct{} //quiet groups are waiting to be written
+
+	mu      sync.Mutex
+	groups  map[string]*correlationGroup
+	quiet   []*correlationGroup //replaced by a new group with the same key, written by run
+	written uint64
+}
+
+var correlation struct {
+	sync.RWMutex
+	current *correlator
+}
+
+//Start correlating, replacing the running correlation, or stop it if the command has none
+func handleCorrelate(cmd *Command) error {
+	if cmd.Correlation == nil {
+		stopCorrelation()
+		return nil
+	}
+	if err := ValidConfig(&Config{Sink: cmd.Sink, Correlation: cmd.Correlation}); err != nil {
+		return err
+	}
+	return startCorrelation(*cmd.Correlation, cmd.Sink)
+}
+
+func startCorrelation(c Correlation, sink Sink) error {
+	window := defaultCorrelationWindow
+	if len(c.Window) != 0 {
+		var err error
+		if window, err = time.ParseDuration(c.Window); err != nil {
+			return err
+		}
+	}
+	if len(c.Keys) == 0 {
+		c.Keys = defaultCorrelationKeys
+	}
+	if c.MinNodes == 0 {
+		c.MinNodes = defaultCorrelationMinNodes
+	}
+	if err := ensureDatabase(sink); err != nil {
+		return errors.New(fmt.Sprintf("ERROR - Sink: %s failed to create database: %v", sink, err))
+	}
+	cr := &correlator{
+		Correlation: c,
+		sink:        sink,
+		window:      window,
+		peerKeys:    make(map[string]bool),
+		done:        make(chan struct{}),
+		wake:        make(chan struct{}, 1),
+		groups:      make(map[string]*correlationGroup),
+	}
+	for _, key := range dialPeerKeys {
+		cr.peerKeys[key] = true
+	}
+	cr.ctx, cr.cancel = context.WithCancel(context.Background())
+
+	stopCorrelation()
+	correlation.Lock()
+	correlation.current = cr
+	correlation.Unlock()
+	infolog.Printf("Correlation started, by %v within %s\n", c.Keys, window)
+	go cr.run()
+	return nil
+}
+
+//Stop correlating, writing the operations still open
+func stopCorrelation() {
+	correlation.Lock()
+	cr := correlation.current
+	correlation.current = nil
+	correlation.Unlock()
+	if cr == nil {
+		return
+	}
+	cr.cancel()
+	<-cr.done
+	infolog.Printf("Correlation stopped, operations written: %d\n", atomic.LoadUint64(&cr.written))
+}
+
+//Hand a filtered event of a node to the correlation, if there is one
+func correlate(node string, le LogEvent) {
+	correlation.RLock()
+	cr := correlation.current
+	correlation.RUnlock()
+	if cr != nil {
+		cr.add(node, le, time.Now())
+	}
+}
+
+func (cr *correlator) add(node string, le LogEvent, now time.Time) {
+	var ce *CorrelatedEvent
+	quiet := false
+	cr.mu.Lock()
+	for _, key := range cr.Keys {
+		value := messageString(le, key)
+		if len(value) == 0 {
+			continue
+		}
+		if cr.peerKeys[key] {
+			value = peerPair(node, value)
+		}
+		if key == correlationRequestKey {
+			scope := messageString(le, "session")
+			if len(scope) == 0 {
+				scope = eventPeerPair(node, le)
+			}
+			if len(scope) == 0 {
+				continue
+			}
+			value = value + "," + scope
+		}
+		if ce == nil {
+			ce = correlatedEvent(node, le, now)
+		}
+		id := key + "\x00" + value
+		g := cr.groups[id]
+		if g != nil && (ce.Time.Sub(g.last) > cr.window || g.first.Sub(ce.Time) > cr.window) {
+			cr.quiet = append(cr.quiet, g)
+			quiet = true
+			g = nil
+		}
+		if g == nil {
+			g = &correlationGroup{key: key, value: value, nodes: make(map[string]bool), first: ce.Time, last: ce.Time}
+			cr.groups[id] = g
+		}
+		if ce.Time.Before(g.first) {
+			g.first = ce.Time
+		}
+		if ce.Time.After(g.last) {
+			g.last = ce.Time
+		}
+		g.seen = now
+		g.nodes[node] = true
+		if len(g.events) < correlationMaxEvents {
+			g.events = append(g.events, *ce)
+		} else {
+			g.dropped++
+		}
+	}
+	cr.mu.Unlock()
+	//written by run, so the proxy is not held up by the sink
+	if quiet {
+		select {
+		case cr.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+//Both ends of a connection name the other as the peer, so they join on the pair
+func peerPair(node, peer string) string {
+	if len(node) == 0 {
+		return peer
+	}
+	if node < peer {
+		return node + "," + peer
+	}
+	return peer + "," + node
+}
+
+//Returns the pair of nodes of the first peer key the event has
+func eventPeerPair(node string, le LogEvent) string {
+	for _, key := range dialPeerKeys {
+		if peer := messageString(le, key); len(peer) != 0 {
+			return peerPair(node, peer)
+		}
+	}
+	return ""
+}
+
+//The time of the event is corrected for the clock of its node before it gets here
+func correlatedEvent(node string, le LogEvent, now time.Time) *CorrelatedEvent {
+	ce := &CorrelatedEvent{
+		Node:     node,
+		System:   le.System,
+		Event:    le.Event,
+		Time:     le.Time,
+		Duration: le.Duration,
+	}
+	if ce.Time.IsZero() {
+		ce.Time = now
+	}
+	return ce
+}
+
+//Write the operations which went quiet, every few moments of the window
+func (cr *correlator) run() {
+	defer close(cr.done)
+	ticker := time.NewTicker(cr.window / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cr.ctx.Done():
+			cr.flush(time.Time{})
+			return
+		case now := <-ticker.C:
+			cr.flush(now)
+		case <-cr.wake:
+			cr.flush(time.Now())
+		}
+	}
+}
+
+//Write the operations without events for the window before now, or all of them if now is zero
+func (cr *correlator) flush(now time.Time) {
+	cr.mu.Lock()
+	quiet := cr.quiet
+	cr.quiet = nil
+	for id, g := range cr.groups {
+		if now.IsZero() || now.Sub(g.seen) > cr.window {
+			quiet = append(quiet, g)
+			delete(cr.groups, id)
+		}
+	}
+	cr.mu.Unlock()
+	cr.write(quiet)
+}
+
+//Write the operations which span enough nodes to the sink, outside the lock
+//and only from run, so the proxies are not held up by the sink
+func (cr *correlator) write(groups []*correlationGroup) {
+	var batch []byte
+	n := 0
+	for _, g := range groups {
+		if len(g.nodes) < cr.MinNodes {
+			continue
+		}
+		b, err := encodeEvent(cr.sink, g.LogEvent(), correlationMeasurement)
+		if err != nil {
+			errlog.Println("Correlation marshal: ", err)
+			continue
+		}
+		batch = append(batch, b...)
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	if err := writeSink(cr.sink, batch); err != nil {
+		errlog.Printf("Correlation Sink: %s write: %v", cr.sink, err)
+		return
+	}
+	atomic.AddUint64(&cr.written, uint64(n))
+}
+
+//The operation as one event, spanning from its first to its last event,
+//with the path it took from node to node
+func (g *correlationGroup) LogEvent() LogEvent {
+	sort.SliceStable(g.events, func(i, j int) bool {
+		return g.events[i].Time.Before(g.events[j].Time)
+	})
+	first := g.events[0].Time
+	end := g.events[len(g.events)-1].Time
+	var nodes []string
+	for node := range g.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	le := NewLogEvent(map[string]interface{}{
+		"system":   correlationMeasurement,
+		"event":    g.key,
+		"time":     first.UTC().Format(time.RFC3339Nano),
+		"duration": float64(end.Sub(first)),
+		"nodeIds":  nodes,
+		"path":     g.events,
+	})
+	//a field, as a tag each operation would be a series of its own
+	le.ExtraFields = map[string]interface{}{
+		"key":    g.value,
+		"nodes":  len(nodes),
+		"events": len(g.events) + g.dropped,
+	}
+	return le
+}
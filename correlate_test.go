@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func testCorrelator() *correlator {
+	cr := &correlator{
+		Correlation: Correlation{Keys: defaultCorrelationKeys, MinNodes: 2},
+		window:      time.Second,
+		peerKeys:    map[string]bool{"peerID": true},
+		wake:        make(chan struct{}, 1),
+		groups:      make(map[string]*correlationGroup),
+	}
+	return cr
+}
+
+func TestCorrelateAcrossNodes(t *testing.T) {
+	cr := testCorrelator()
+	now := time.Unix(1700000000, 0)
+	cr.add("QmA", NewLogEvent(map[string]interface{}{"system": "dht", "event": "findProviders", "time": "2017-11-17T22:09:10Z", "requestId": "r1", "session": "s1"}), now)
+	cr.add("QmB", NewLogEvent(map[string]interface{}{"system": "dht", "event": "handleGetProviders", "time": "2017-11-17T22:09:10.5Z", "requestId": "r1", "session": "s1"}), now)
+	//both ends of a connection join on the pair of nodes
+	cr.add("QmA", NewLogEvent(map[string]interface{}{"system": "swarm2", "event": "dial", "time": "2017-11-17T22:09:10Z", "peerID": "QmB"}), now)
+	cr.add("QmB", NewLogEvent(map[string]interface{}{"system": "swarm2", "event": "accept", "time": "2017-11-17T22:09:10Z", "peerID": "QmA"}), now)
+	cr.add("QmA", NewLogEvent(map[string]interface{}{"system": "dht", "event": "unrelated"}), now)
+	if len(cr.groups) != 3 {
+		t.Fatal(fmt.Sprintf("Invalid Groups: %v", cr.groups))
+	}
+	g := cr.groups["requestId\x00r1,s1"]
+	if g == nil || len(g.nodes) != 2 {
+		t.Fatal(fmt.Sprintf("Invalid requestId Group: %v", g))
+	}
+	le := g.LogEvent()
+	path := le.Message["path"].([]CorrelatedEvent)
+	if le.Message["duration"] != float64(500*time.Millisecond) || path[0].Node != "QmA" || path[1].Node != "QmB" {
+		t.Error(fmt.Sprintf("Invalid Operation: %v", le.Message))
+	}
+	//the key is a field, so operations do not each make a series
+	if b, err := le.ToLP(); err != nil || string(b) != `ipfs_correlation,event=requestId duration=500000000.000000,events=2,key="r1,s1",nodes=2 1510956550000000000`+"\n" {
+		t.Error(fmt.Sprintf("Invalid Line Protocol: %s %v", b, err))
+	}
+	if g := cr.groups["peerID\x00QmA,QmB"]; g == nil || len(g.events) != 2 {
+		t.Error(fmt.Sprintf("Invalid peerID Group: %v", g))
+	}
+}
+
+func TestCorrelateRequestIds(t *testing.T) {
+	cr := testCorrelator()
+	cr.Keys = []string{"requestId"}
+	now := time.Unix(1700000000, 0)
+	//request ids are counted on each node, alone they join nothing
+	cr.add("QmA", NewLogEvent(map[string]interface{}{"system": "dht", "event": "query", "time": "2017-11-17T22:09:10Z", "requestId": 1}), now)
+	cr.add("QmB", NewLogEvent(map[string]interface{}{"system": "dht", "event": "query", "time": "2017-11-17T22:09:10Z", "requestId": 1}), now)
+	if len(cr.groups) != 0 {
+		t.Error(fmt.Sprintf("Joined on a request id alone: %v", cr.groups))
+	}
+	//with the peer they join on the pair of nodes
+	cr.add("QmA", NewLogEvent(map[string]interface{}{"system": "dht", "event": "query", "time": "2017-11-17T22:09:10Z", "requestId": 1, "peer": "QmB"}), now)
+	cr.add("QmB", NewLogEvent(map[string]interface{}{"system": "dht", "event": "handle", "time": "2017-11-17T22:09:10Z", "requestId": 1, "peer": "QmA"}), now)
+	cr.add("QmC", NewLogEvent(map[string]interface{}{"system": "dht", "event": "query", "time": "2017-11-17T22:09:10Z", "requestId": 1, "peer": "QmD"}), now)
+	if g := cr.groups["requestId\x001,QmA,QmB"]; len(cr.groups) != 2 || g == nil || len(g.nodes) != 2 {
+		t.Error(fmt.Sprintf("Invalid Groups: %v", cr.groups))
+	}
+}
+
+func TestCorrelateWindow(t *testing.T) {
+	cr := testCorrelator()
+	cr.MinNodes = 3 //nothing is written
+	now := time.Unix(1700000000, 0)
+	event := func(ts string) LogEvent {
+		return NewLogEvent(map[string]interface{}{"system": "dht", "event": "query", "time": ts, "session": "s1"})
+	}
+	//the window is between the times of the events, not when they arrive
+	cr.add("QmA", event("2017-11-17T22:09:10Z"), now)
+	cr.add("QmB", event("2017-11-17T22:09:10.5Z"), now)
+	cr.add("QmC", event("2017-11-17T22:09:12.5Z"), now)
+	g := cr.groups["session\x00s1"]
+	if len(g.events) != 1 || !g.nodes["QmC"] {
+		t.Error(fmt.Sprintf("Expected a new operation: %v", g))
+	}
+	//an event arriving late still joins its operation
+	cr.add("QmA", event("2017-11-17T22:09:12Z"), now.Add(3*time.Second))
+	if g := cr.groups["session\x00s1"]; len(g.events) != 2 {
+		t.Error(fmt.Sprintf("Late event not joined: %v", g))
+	}
+	//the old one is left for run to write
+	if len(cr.quiet) != 1 || len(cr.wake) != 1 {
+		t.Error(fmt.Sprintf("Quiet operation not handed to run: %v", cr.quiet))
+	}
+	cr.flush(now.Add(3500 * time.Millisecond))
+	if len(cr.groups) != 1 || len(cr.quiet) != 0 {
+		t.Error(fmt.Sprintf("Flushed too early: %v", cr.groups))
+	}
+	cr.flush(now.Add(5 * time.Second))
+	if len(cr.groups) != 0 {
+		t.Error(fmt.Sprintf("Not flushed: %v", cr.groups))
+	}
+}
+
+func TestCorrelationWindowMin(t *testing.T) {
+	for window, valid := range map[string]bool{"": true, "100ms": true, "1ns": false, "-1s": false, "soon": false} {
+		err := ValidConfig(&Config{Sink: Sink{Format: "json"}, Correlation: &Correlation{Window: window}})
+		if (err == nil) != valid {
+			t.Error(fmt.Sprintf("Invalid Validation of Window: %s %v", window, err))
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDiscoveryInterval = 30 * time.Second
+	defaultAPIPort           = "5001"
+	discoveryProbes          = 16 //candidates probed at once
+	//rounds a discovered source may be missing before it is removed
+	discoveryMisses = 3
+)
+
+//Adds the sources it finds each round and removes the ones which went away
+type discoverer struct {
+	Discovery
+	sink     Sink
+	interval time.Duration
+	ctx      context.Context
+	cancel   context.CancelFunc
+	found    *discoveredSources
+}
+
+//Sources added by discovery, kept when discovery is replaced so they are not added twice
+type discoveredSources struct {
+	sync.Mutex
+	added  map[string]string //address of a discovered source to the name of its proxy
+	missed map[string]int
+}
+
+var discovery struct {
+	sync.Mutex
+	current *discoverer
+}
+
+//Start discovery, replacing the running one, or stop it if the command has none
+func handleDiscover(cmd *Command) error {
+	if cmd.Discovery == nil {
+		stopDiscovery()
+		return nil
+	}
+	if err := ValidConfig(&Config{Sink: cmd.Sink, Discovery: cmd.Discovery}); err != nil {
+		return err
+	}
+	return startDiscovery(*cmd.Discovery, cmd.Sink)
+}
+
+func startDiscovery(d Discovery, sink Sink) error {
+	interval := defaultDiscoveryInterval
+	if len(d.Interval) != 0 {
+		var err error
+		if interval, err = time.ParseDuration(d.Interval); err != nil {
+			return err
+		}
+	}
+	if len(d.APIPort) == 0 {
+		d.APIPort = defaultAPIPort
+	}
+	dc := &discoverer{
+		Discovery: d,
+		sink:      sink,
+		interval:  interval,
+	}
+	dc.ctx, dc.cancel = context.WithCancel(context.Background())
+
+	discovery.Lock()
+	defer discovery.Unlock()
+	if discovery.current != nil {
+		discovery.current.cancel()
+		dc.found = discovery.current.found
+	} else {
+		dc.found = &discoveredSources{
+			added:  make(map[string]string),
+			missed: make(map[string]int),
+		}
+	}
+	discovery.current = dc
+	infolog.Printf("Discovery started, every %s\n", interval)
+	go dc.run()
+	return nil
+}
+
+//Stop discovering sources, the ones already added keep being collected
+func stopDiscovery() {
+	discovery.Lock()
+	defer discovery.Unlock()
+	if discovery.current != nil {
+		discovery.current.cancel()
+		discovery.current = nil
+		infolog.Println("Discovery stopped")
+	}
+}
+
+func (dc *discoverer) run() {
+	ticker := time.NewTicker(dc.interval)
+	defer ticker.Stop()
+	for {
+		dc.round()
+		select {
+		case <-dc.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//Probe the candidates, add new nodes and remove the ones missing for too long
+func (dc *discoverer) round() {
+	found := dc.found
+	//a replaced discoverer may still be finishing its round
+	found.Lock()
+	defer found.Unlock()
+
+	seen := make(map[string]bool)
+	names := make(map[string]bool)
+	for _, name := range found.added {
+		names[name] = true
+	}
+	var probe []Source
+	for _, candidate := range dc.candidates() {
+		key := candidate.String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if name, ok := found.added[key]; ok {
+			if getProxy(name) != nil {
+				continue
+			}
+			//the proxy failed to start or was removed, try again
+			delete(found.added, key)
+			delete(names, name)
+		}
+		probe = append(probe, candidate)
+	}
+
+	ids := dc.probe(probe)
+	for i, candidate := range probe {
+		if dc.ctx.Err() != nil {
+			return
+		}
+		if dc.MaxNodes > 0 && len(found.added) >= dc.MaxNodes {
+			break
+		}
+		//failed probes have no id, and the same node may be found under several addresses
+		if len(ids[i]) == 0 {
+			continue
+		}
+		name := dc.Source.proxyName(ids[i])
+		if names[name] || getProxy(name) != nil {
+			continue
+		}
+		source := dc.Source
+		source.Address = candidate.Address
+		source.Port = candidate.Port
+		source.Tags = append([]Tag(nil), dc.Source.Tags...)
+		source.Filters = append([]Filter(nil), dc.Source.Filters...)
+		infolog.Printf("Discovered Source: %s Name: %s\n", source, name)
+		startProxy(ids[i], source, dc.sink)
+		found.added[candidate.String()] = name
+		names[name] = true
+	}
+
+	for key, name := range found.added {
+		if seen[key] {
+			delete(found.missed, key)
+			continue
+		}
+		found.missed[key]++
+		if found.missed[key] < discoveryMisses {
+			continue
+		}
+		infolog.Printf("Source: %s Name: %s no longer discovered, removing\n", key, name)
+		if getProxy(name) != nil {
+			handleRemoveCollection(name)
+		}
+		delete(found.added, key)
+		delete(found.missed, key)
+	}
+}
+
+//Probe the candidates in parallel, returns their node ids, empty where the probe failed
+func (dc *discoverer) probe(candidates []Source) []string {
+	ids := make([]string, len(candidates))
+	slots := make(chan struct{}, discoveryProbes)
+	var wg sync.WaitGroup
+	for i, candidate := range candidates {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, candidate Source) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			ids[i], _ = GetNodeId(dc.ctx, candidate)
+		}(i, candidate)
+	}
+	wg.Wait()
+	return ids
+}
+
+//Gather the api addresses of every configured kind of discovery
+func (dc *discoverer) candidates() []Source {
+	var sources []Source
+	add := func(input string) {
+		address, port, err := ParseInput(input)
+		if err != nil {
+			errlog.Printf("Discovery: %v: %s\n", err, input)
+			return
+		}
+		sources = append(sources, Source{Address: address, Port: port})
+	}
+	if len(dc.Seed) != 0 {
+		add(dc.Seed)
+		peers, err := dc.seedPeers()
+		if err != nil {
+			errlog.Println("Discovery seed peers: ", err)
+		}
+		for _, peer := range peers {
+			add(peer)
+		}
+	}
+	for _, addr := range dc.Static {
+		add(addr)
+	}
+	if len(dc.SRV) != 0 {
+		_, records, err := net.LookupSRV("", "", dc.SRV)
+		if err != nil {
+			errlog.Println("Discovery SRV: ", err)
+		}
+		for _, r := range records {
+			add(net.JoinHostPort(strings.TrimSuffix(r.Target, "."), strconv.Itoa(int(r.Port))))
+		}
+	}
+	if len(dc.Repos) != 0 {
+		repos, _ := filepath.Glob(dc.Repos)
+		for _, repo := range repos {
+			//repos of stopped daemons have no api file
+			if ma, err := ReadRepoAPI(repo); err == nil {
+				add(ma)
+			}
+		}
+	}
+	return sources
+}
+
+//Returns the api multiaddrs of the swarm peers of the seed, assuming they listen on the api port
+func (dc *discoverer) seedPeers() ([]string, error) {
+	address, port, err := ParseInput(dc.Seed)
+	if err != nil {
+		return nil, err
+	}
+	var peers struct {
+		Peers []struct {
+			Addr string
+		}
+	}
+	if err := postAPIJSON(dc.ctx, Source{Address: address, Port: port}, "/api/v0/swarm/peers", &peers); err != nil {
+		return nil, err
+	}
+	var apis []string
+	for _, p := range peers.Peers {
+		//the swarm address of a peer tells us its host, e.g. /ip4/10.0.0.2/tcp/4001
+		parts := strings.Split(strings.TrimPrefix(p.Addr, "/"), "/")
+		if len(parts) < 2 {
+			continue
+		}
+		switch parts[0] {
+		case "ip4", "ip6", "dns", "dns4", "dns6":
+			apis = append(apis, fmt.Sprintf("/%s/%s/tcp/%s", parts[0], parts[1], dc.APIPort))
+		}
+	}
+	return apis, nil
+}
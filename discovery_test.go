@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiscoveryMisses(t *testing.T) {
+	node := newTestNode(t)
+	defer closeSinkFiles()
+	dc := &discoverer{
+		Discovery: Discovery{Static: []string{net.JoinHostPort(node.Source.Address, node.Source.Port)}},
+		sink:      Sink{Format: "json", File: filepath.Join(t.TempDir(), "events.json")},
+		found: &discoveredSources{
+			added:  make(map[string]string),
+			missed: make(map[string]int),
+		},
+	}
+	dc.ctx, dc.cancel = context.WithCancel(context.Background())
+	defer dc.cancel()
+	defer func() {
+		if getProxy("QmNode") != nil {
+			handleRemoveCollection("QmNode")
+		}
+	}()
+
+	dc.round()
+	waitFor(t, "discovered proxy", func() bool { return getProxy("QmNode") != nil })
+	dc.round()
+	if len(dc.found.added) != 1 || len(dc.found.missed) != 0 {
+		t.Fatal(fmt.Sprintf("Invalid Discovered: %v missed: %v", dc.found.added, dc.found.missed))
+	}
+
+	//the node is kept until it has been missing for discoveryMisses rounds
+	dc.Static = nil
+	for i := 1; i < discoveryMisses; i++ {
+		dc.round()
+		if getProxy("QmNode") == nil {
+			t.Fatal(fmt.Sprintf("Removed After %d Misses", i))
+		}
+	}
+	dc.round()
+	if getProxy("QmNode") != nil || len(dc.found.added) != 0 || len(dc.found.missed) != 0 {
+		t.Error(fmt.Sprintf("Not Removed After %d Misses: %v %v", discoveryMisses, dc.found.added, dc.found.missed))
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//A Filter matches a field of a log event message against a value,
+//events which do not pass every filter of a source are dropped
+type Filter struct {
+	Field   string `json:"Field"`
+	Value   string `json:"Value"`
+	Exclude bool   `json:"Exclude"` //drop matching events instead of keeping them
+}
+
+func (f Filter) String() string {
+	if f.Exclude {
+		return fmt.Sprintf("%s!=%s", f.Field, f.Value)
+	}
+	return fmt.Sprintf("%s=%s", f.Field, f.Value)
+}
+
+//Returns true if the event should be kept
+func (f Filter) Pass(le LogEvent) bool {
+	v, ok := le.Message[f.Field]
+	match := ok && fmt.Sprint(v) == f.Value
+	return match != f.Exclude
+}
+
+//Returns true if the event passes all filters
+func PassFilters(filters []Filter, le LogEvent) bool {
+	for _, f := range filters {
+		if !f.Pass(le) {
+			return false
+		}
+	}
+	return true
+}
+
+//Parses a filter in the form field=value or field!=value
+func MakeFilter(maybeFilter string) (Filter, error) {
+	exclude := strings.Contains(maybeFilter, "!=")
+	sep := "="
+	if exclude {
+		sep = "!="
+	}
+	fv := strings.SplitN(maybeFilter, sep, 2)
+	if len(fv) != 2 || len(fv[0]) == 0 || len(fv[1]) == 0 {
+		return Filter{}, errors.New(fmt.Sprintf("Invalid filter: %v", maybeFilter))
+	}
+	return Filter{
+		Field:   fv[0],
+		Value:   fv[1],
+		Exclude: exclude,
+	}, nil
+}
+
+func MakeFilters(filters []string) ([]Filter, error) {
+	var fs []Filter
+	for _, f := range filters {
+		filter, err := MakeFilter(f)
+		if err != nil {
+			return nil, err
+		}
+		fs = append(fs, filter)
+	}
+	return fs, nil
+}
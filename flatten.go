@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+//How arrays are flattened
+const (
+	FlattenJoin    = "join"    //one string field with the elements joined, the default
+	FlattenExplode = "explode" //a field per element, named by its index
+)
+
+const (
+	defaultFlattenSeparator      = "."
+	defaultFlattenArraySeparator = ","
+)
+
+//Message keys which line protocol already writes as the measurement, a tag or a field
+func lpMessageKey(key string) bool {
+	if key == "system" || key == "time" {
+		return true
+	}
+	for _, k := range messageTags {
+		if k == key {
+			return true
+		}
+	}
+	for _, k := range messageFields {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+//Returns the rest of the message of an event as fields, nested objects named
+//by their keys joined with the separator. Extra fields of the event win over the message.
+func (f *Flatten) Message(le *LogEvent) map[string]interface{} {
+	flat := make(map[string]interface{})
+	for key, value := range le.Message {
+		if lpMessageKey(key) {
+			continue
+		}
+		f.add(flat, key, value)
+	}
+	for name := range le.ExtraFields {
+		delete(flat, name)
+	}
+	return flat
+}
+
+func (f *Flatten) add(flat map[string]interface{}, name string, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+	case map[string]interface{}:
+		for key, value := range v {
+			f.add(flat, name+f.separator()+key, value)
+		}
+	case []interface{}:
+		if f.Arrays == FlattenExplode {
+			for i, value := range v {
+				f.add(flat, name+f.separator()+strconv.Itoa(i), value)
+			}
+			return
+		}
+		var elems []string
+		for _, value := range v {
+			elems = append(elems, elementString(value))
+		}
+		if len(elems) != 0 {
+			flat[name] = strings.Join(elems, f.arraySeparator())
+		}
+	case string, bool, float64, float32, json.Number, uint, uint8, uint16, uint32, uint64, int, int8, int16, int32, int64:
+		flat[name] = v
+	default:
+		//events made in the daemon hold structs and typed slices, which are
+		//flattened like the json they are written as
+		b, err := json.Marshal(v)
+		if err != nil {
+			return
+		}
+		var decoded interface{}
+		if json.Unmarshal(b, &decoded) == nil {
+			f.add(flat, name, decoded)
+		}
+	}
+}
+
+//An element of a joined array, nested ones as json
+func elementString(v interface{}) string {
+	if s, ok := tagString(v); ok {
+		return s
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func (f *Flatten) separator() string {
+	if len(f.Separator) == 0 {
+		return defaultFlattenSeparator
+	}
+	return f.Separator
+}
+
+func (f *Flatten) arraySeparator() string {
+	if len(f.ArraySeparator) == 0 {
+		return defaultFlattenArraySeparator
+	}
+	return f.ArraySeparator
+}
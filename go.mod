@@ -1,3 +1,10 @@
 module github.com/ipfs/ipfs-metrics
 
-require github.com/codegangsta/cli v1.20.0
+require (
+	github.com/BurntSushi/toml v1.2.1
+	github.com/codegangsta/cli v1.20.0
+	golang.org/x/term v0.5.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/sys v0.5.0 // indirect
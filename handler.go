@@ -7,111 +7,262 @@ import (
 	"net/http"
 )
 
-//Handle request, add, remove, list
+//Handle request, add, remove, list, stats, levels, pause, resume, update, discover, correlate, record, stop-record
 func handleConnection(w http.ResponseWriter, r *http.Request) {
 	dec := json.NewDecoder(r.Body)
 	cmd := &Command{}
 	dec.Decode(cmd)
 	cmd.Response = w
 
-	var result = "Success"
 	switch cmd.Type {
 	case "add":
-		err := handleAddCollection(cmd)
-		if err != nil {
-			result = err.Error()
-		}
-		cmd.Result = result
-		printCmd, _ := json.MarshalIndent(cmd, "", "\t")
-		fmt.Fprintf(cmd.Response, string(printCmd))
+		writeResult(cmd, handleAddCollection(cmd))
 		return
 	case "remove":
-		err := handleRemoveCollection(cmd.Node)
-		if err != nil {
-			result = err.Error()
-		}
-		cmd.Result = result
-		printCmd, _ := json.MarshalIndent(cmd, "", "\t")
-		fmt.Fprintf(cmd.Response, string(printCmd))
+		writeResult(cmd, handleRemoveCollection(cmd.Node))
+		return
+	case "pause":
+		writeResult(cmd, handlePauseCollection(cmd.Node))
+		return
+	case "resume":
+		writeResult(cmd, handleResumeCollection(cmd.Node))
+		return
+	case "update":
+		writeResult(cmd, handleUpdateCollection(cmd))
+		return
+	case "discover":
+		writeResult(cmd, handleDiscover(cmd))
+		return
+	case "correlate":
+		writeResult(cmd, handleCorrelate(cmd))
+		return
+	case "record":
+		writeResult(cmd, handleRecordCollection(cmd))
+		return
+	case "stop-record":
+		writeResult(cmd, handleStopRecordCollection(cmd))
 		return
 	case "list":
-		err := handleListCollection(cmd)
-		if err != nil {
-			result = err.Error()
-		}
+		handleListCollection(cmd)
 		break
+	case "stats":
+		if err := handleStatsCollection(cmd); err != nil {
+			writeResult(cmd, err)
+		}
+		return
+	case "levels":
+		if err := handleLevels(cmd); err != nil {
+			writeResult(cmd, err)
+		}
+		return
 	}
 	return
 }
 
+//Write the command back to the client with its result
+func writeResult(cmd *Command, err error) {
+	cmd.Result = "Success"
+	if err != nil {
+		cmd.Result = err.Error()
+	}
+	printCmd, _ := json.MarshalIndent(cmd, "", "\t")
+	cmd.Response.Write(printCmd)
+}
+
+func getProxy(name string) *LogProxy {
+	proxyLock.RLock()
+	defer proxyLock.RUnlock()
+	return proxyList[name]
+}
+
+func addProxy(lp *LogProxy) {
+	proxyLock.Lock()
+	defer proxyLock.Unlock()
+	proxyList[lp.Name] = lp
+}
+
+func deleteProxy(name string) {
+	proxyLock.Lock()
+	defer proxyLock.Unlock()
+	delete(proxyList, name)
+}
+
+//Remove the proxy if it is still the one in the collection under its name
+func removeProxy(lp *LogProxy) bool {
+	proxyLock.Lock()
+	defer proxyLock.Unlock()
+	if proxyList[lp.Name] != lp {
+		return false
+	}
+	delete(proxyList, lp.Name)
+	return true
+}
+
+//Returns every proxy in the collection
+func proxies() []*LogProxy {
+	proxyLock.RLock()
+	defer proxyLock.RUnlock()
+	var lps []*LogProxy
+	for _, lp := range proxyList {
+		lps = append(lps, lp)
+	}
+	return lps
+}
+
 //Remove a source from the collection
 func handleRemoveCollection(node string) error {
-	lp := proxyList[node]
+	lp := getProxy(node)
 	if lp == nil {
 		err := errors.New(fmt.Sprintf("ERROR - Source: %s not in collection", node))
 		return err //since this needs to go to the client
 	}
 	lp.Close()
-	delete(proxyList, node)
+	deleteProxy(node)
 	return nil
 
 }
 
+//Pause reading from a source in the collection
+func handlePauseCollection(node string) error {
+	lp := getProxy(node)
+	if lp == nil {
+		return errors.New(fmt.Sprintf("ERROR - Source: %s not in collection", node))
+	}
+	return lp.Pause()
+}
+
+//Resume reading from a paused source in the collection
+func handleResumeCollection(node string) error {
+	lp := getProxy(node)
+	if lp == nil {
+		return errors.New(fmt.Sprintf("ERROR - Source: %s not in collection", node))
+	}
+	return lp.Resume()
+}
+
+//Swap the tags, filters or sink of a source in the collection
+func handleUpdateCollection(cmd *Command) error {
+	lp := getProxy(cmd.Node)
+	if lp == nil {
+		return errors.New(fmt.Sprintf("ERROR - Source: %s not in collection", cmd.Node))
+	}
+	update := ProxyUpdate{
+		Tags:    cmd.Tags,
+		Filters: cmd.Filters,
+	}
+	if len(cmd.Sink.Format) != 0 {
+		sink := cmd.Sink
+		update.Sink = &sink
+	}
+	return lp.Update(update)
+}
+
+//Record the raw events of a source in the collection, the archive is written back in the result
+func handleRecordCollection(cmd *Command) error {
+	lp := getProxy(cmd.Node)
+	if lp == nil {
+		return errors.New(fmt.Sprintf("ERROR - Source: %s not in collection", cmd.Node))
+	}
+	path, err := lp.StartRecording(cmd.Record)
+	cmd.Record = path
+	return err
+}
+
+//Stop recording a source in the collection
+func handleStopRecordCollection(cmd *Command) error {
+	lp := getProxy(cmd.Node)
+	if lp == nil {
+		return errors.New(fmt.Sprintf("ERROR - Source: %s not in collection", cmd.Node))
+	}
+	path, err := lp.StopRecording()
+	cmd.Record = path
+	return err
+}
+
 type ListResult struct {
-	Name   string `json:"name"`
-	Source Source `json:"source"`
-	Sink   Sink   `json:"sink"`
-	Format string `json:"format"`
-	Tags   []Tag  `json:"tags"`
+	Name    string   `json:"name"`
+	State   string   `json:"state"`
+	Source  Source   `json:"source"`
+	Sink    Sink     `json:"sink"`
+	Format  string   `json:"format"`
+	Tags    []Tag    `json:"tags"`
+	Filters []Filter `json:"filters"`
+	Record  string   `json:"record,omitempty"` //archive the raw events are recorded to
 }
 
 //List all sources in collection
 func handleListCollection(cmd *Command) error {
-	if proxyList != nil {
-		for _, lp := range proxyList {
-			lr := &ListResult{
-				Name:   lp.Name,
-				Source: lp.Source,
-				Sink:   lp.Sink,
-			}
-			ent, err := json.MarshalIndent(lr, "", "\t")
-			if err != nil {
-				panic(err)
-			}
-			fmt.Fprintf(cmd.Response, string(ent))
+	for _, lp := range proxies() {
+		state := lp.State()
+		lp.mu.RLock()
+		lr := &ListResult{
+			Name:    lp.Name,
+			State:   state,
+			Source:  lp.Source,
+			Sink:    lp.Sink.redacted(),
+			Format:  lp.Sink.Format,
+			Tags:    lp.Source.Tags,
+			Filters: lp.Source.Filters,
+		}
+		if lp.recorder != nil {
+			lr.Record = lp.recorder.Path
+		}
+		lp.mu.RUnlock()
+		ent, err := json.MarshalIndent(lr, "", "\t")
+		if err != nil {
+			panic(err)
 		}
+		cmd.Response.Write(ent)
 	}
 	return nil
 }
 
 //Add a source to the collection
 func handleAddCollection(cmd *Command) error {
+	//the web ui sends commands without the checks of the cli
+	if err := ValidConfig(&Config{Source: cmd.Source, Sink: cmd.Sink, Discovery: cmd.Discovery, Correlation: cmd.Correlation}); err != nil {
+		return err
+	}
 	//start a routine for each source, if there is an error with one, skip it
 	for s := range cmd.Source {
 		source := cmd.Source[s]
-		name, err := GetNodeId(source)
+		nodeId, err := sourceNodeId(source)
 		if err != nil {
 			//TODO Add feature to start and stop collection on different sources
 			//e.g. add the source with status offline and poll it till its up/producing logs
-			fmt.Fprintf(cmd.Response, "Failed to get NodeId: "+err.Error()+" will skip")
+			fmt.Fprint(cmd.Response, "Failed to get NodeId: "+err.Error()+" will skip")
 			continue
 		}
+		name := source.proxyName(nodeId)
 		//we do not want to add the same source twice
-		if proxyList[name] != nil {
+		if getProxy(name) != nil {
 			err := fmt.Sprintf("Source: %s, with Name: %s already in collection, will skip", source, name)
-			fmt.Fprintf(cmd.Response, err)
+			fmt.Fprint(cmd.Response, err)
 			continue
 		}
-		source.Tags = append(source.Tags, MakeTag("nodeId", name))
-		lp := &LogProxy{
-			Name:     name,
-			Source:   source,
-			Sink:     cmd.Sink,
-			Inbound:  make(chan LogEvent, 64),
-			Outbound: make(chan LogEvent, 64),
+		startProxy(nodeId, source, cmd.Sink)
+	}
+	if cmd.Correlation != nil {
+		if err := startCorrelation(*cmd.Correlation, cmd.Sink); err != nil {
+			return err
 		}
-		go lp.Start()
 	}
-
+	if cmd.Discovery != nil {
+		return startDiscovery(*cmd.Discovery, cmd.Sink)
+	}
 	return nil
 }
+
+//Start collecting from the source of the node
+func startProxy(nodeId string, source Source, sink Sink) {
+	source.Tags = append(source.Tags, MakeTag("nodeId", nodeId))
+	lp := &LogProxy{
+		Name:     source.proxyName(nodeId),
+		NodeId:   nodeId,
+		Source:   source,
+		Sink:     sink,
+		Inbound:  make(chan LogEvent, source.bufferSize()),
+		Outbound: make(chan LogEvent, source.bufferSize()),
+	}
+	go lp.Start()
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+//Levels go-log v1 and v2 accept
+var logLevels = []string{"debug", "info", "notice", "warn", "warning", "error", "critical", "dpanic", "panic", "fatal"}
+
+//Subsystem which sets the level of every subsystem, * does too, it is set before the others
+const logLevelAll = "all"
+
+func validLogLevel(level string) bool {
+	for _, l := range logLevels {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
+
+//Parse subsystem=level pairs, e.g. dht=debug
+func ParseLogLevels(args []string) (map[string]string, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	levels := make(map[string]string, len(args))
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 || len(kv[0]) == 0 || !validLogLevel(strings.ToLower(kv[1])) {
+			return nil, errors.New(fmt.Sprintf("Invalid log level: %s, expected subsystem=level with a level of %s", arg, strings.Join(logLevels, ", ")))
+		}
+		levels[kv[0]] = strings.ToLower(kv[1])
+	}
+	return levels, nil
+}
+
+//Subsystems in the order their levels are set, so all is overridden by the others
+func logLevelOrder(levels map[string]string) []string {
+	var subsystems []string
+	for subsystem := range levels {
+		subsystems = append(subsystems, subsystem)
+	}
+	sort.Slice(subsystems, func(i, j int) bool {
+		a, b := isAllSubsystems(subsystems[i]), isAllSubsystems(subsystems[j])
+		if a != b {
+			return a
+		}
+		return subsystems[i] < subsystems[j]
+	})
+	return subsystems
+}
+
+func isAllSubsystems(subsystem string) bool {
+	return subsystem == logLevelAll || subsystem == "*"
+}
+
+//Returns the level set for every subsystem, if there is one
+func allLogLevel(levels map[string]string) (string, bool) {
+	for subsystem, level := range levels {
+		if isAllSubsystems(subsystem) {
+			return level, true
+		}
+	}
+	return "", false
+}
+
+//Set the level of a subsystem of the node
+func setLogLevel(ctx context.Context, source Source, subsystem, level string) error {
+	if isAllSubsystems(subsystem) {
+		subsystem = logLevelAll
+	}
+	var result struct {
+		Message string
+	}
+	path := fmt.Sprintf("/api/v0/log/level?arg=%s&arg=%s", url.QueryEscape(subsystem), url.QueryEscape(level))
+	return postAPIJSON(ctx, source, path, &result)
+}
+
+//Returns the logging subsystems of the node
+func listSubsystems(ctx context.Context, source Source) ([]string, error) {
+	var result struct {
+		Strings []string
+	}
+	if err := postAPIJSON(ctx, source, "/api/v0/log/ls", &result); err != nil {
+		return nil, err
+	}
+	sort.Strings(result.Strings)
+	return result.Strings, nil
+}
+
+//Returns the levels the proxy sets on its node
+func (lp *LogProxy) LogLevels() map[string]string {
+	lp.mu.RLock()
+	defer lp.mu.RUnlock()
+	return lp.Source.LogLevels
+}
+
+//Set the configured levels on the node, when the log stream is opened
+//so a restarted node gets them again
+func (lp *LogProxy) applyLogLevels() {
+	source := lp.source()
+	for _, subsystem := range logLevelOrder(source.LogLevels) {
+		if err := setLogLevel(lp.ctx, source, subsystem, source.LogLevels[subsystem]); err != nil {
+			errlog.Printf("Source: %s set log level %s=%s: %v", source, subsystem, source.LogLevels[subsystem], err)
+		}
+	}
+}
+
+//Set levels on the node and keep them, to be set again after a reconnect
+func (lp *LogProxy) SetLogLevels(levels map[string]string) error {
+	source := lp.source()
+	for _, subsystem := range logLevelOrder(levels) {
+		if err := setLogLevel(lp.ctx, source, subsystem, levels[subsystem]); err != nil {
+			return err
+		}
+	}
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+	//replaced rather than changed, readers hold on to the old map
+	merged := make(map[string]string, len(lp.Source.LogLevels)+len(levels))
+	//setting every subsystem overrides the levels set before
+	if _, all := allLogLevel(levels); !all {
+		for subsystem, level := range lp.Source.LogLevels {
+			merged[subsystem] = level
+		}
+	}
+	for subsystem, level := range levels {
+		merged[subsystem] = level
+	}
+	lp.Source.LogLevels = merged
+	return nil
+}
+
+//Whether the node of the proxy has levels to set, prometheus and replayed sources do not
+func (lp *LogProxy) hasEventLog() bool {
+	lp.mu.RLock()
+	defer lp.mu.RUnlock()
+	return !lp.Source.isPrometheus() && !lp.Source.isReplay()
+}
+
+type LevelsResult struct {
+	Name       string            `json:"name"`
+	Levels     map[string]string `json:"levels"`               //set by ipfs-metrics, the other subsystems are at the level of the node
+	Subsystems []string          `json:"subsystems,omitempty"` //of the node, if they were asked for
+	Error      string            `json:"error,omitempty"`
+}
+
+//Set or show the log levels of the nodes in the collection
+func handleLevels(cmd *Command) error {
+	var lps []*LogProxy
+	if len(cmd.Node) != 0 {
+		lp := getProxy(cmd.Node)
+		if lp == nil {
+			return errors.New(fmt.Sprintf("ERROR - Source: %s not in collection", cmd.Node))
+		}
+		if !lp.hasEventLog() {
+			return errors.New(fmt.Sprintf("ERROR - Source: %s has no event log", cmd.Node))
+		}
+		lps = append(lps, lp)
+	} else {
+		for _, lp := range proxies() {
+			if lp.hasEventLog() {
+				lps = append(lps, lp)
+			}
+		}
+	}
+	for subsystem, level := range cmd.LogLevels {
+		if len(subsystem) == 0 || !validLogLevel(level) {
+			return errors.New(fmt.Sprintf("ERROR - Invalid log level: %s=%s", subsystem, level))
+		}
+	}
+	var results []LevelsResult
+	for _, lp := range lps {
+		lr := LevelsResult{Name: lp.Name}
+		if len(cmd.LogLevels) != 0 {
+			if err := lp.SetLogLevels(cmd.LogLevels); err != nil {
+				lr.Error = err.Error()
+			}
+		}
+		lr.Levels = lp.LogLevels()
+		if cmd.Subsystems {
+			subsystems, err := listSubsystems(lp.ctx, lp.source())
+			if err != nil && len(lr.Error) == 0 {
+				lr.Error = err.Error()
+			}
+			lr.Subsystems = subsystems
+		}
+		results = append(results, lr)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+	ent, err := json.MarshalIndent(results, "", "\t")
+	if err != nil {
+		return err
+	}
+	cmd.Response.Write(ent)
+	return nil
+}
+
+//Print levels as a table, one row per subsystem of each node
+func printLevels(w io.Writer, results []LevelsResult) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSUBSYSTEM\tLEVEL")
+	for _, lr := range results {
+		if len(lr.Error) != 0 {
+			fmt.Fprintf(tw, "%s\t-\terror: %s\n", lr.Name, lr.Error)
+		}
+		subsystems := lr.Subsystems
+		if len(subsystems) == 0 {
+			subsystems = logLevelOrder(lr.Levels)
+		}
+		all, ok := allLogLevel(lr.Levels)
+		if !ok {
+			all = "-"
+		}
+		for _, subsystem := range subsystems {
+			level := lr.Levels[subsystem]
+			if len(level) == 0 {
+				level = all
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", lr.Name, subsystem, level)
+		}
+	}
+	tw.Flush()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+//An ipfs api which records the levels set on it
+func testLevelsAPI(t *testing.T) (Source, *[]string, func()) {
+	var mu sync.Mutex
+	var set []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//like go-ipfs 0.5 and later
+		if r.Method != "POST" {
+			http.Error(w, "405 - Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v0/log/level":
+			args := r.URL.Query()["arg"]
+			mu.Lock()
+			set = append(set, strings.Join(args, "="))
+			mu.Unlock()
+			fmt.Fprintf(w, `{"Message":"Changed log level of '%s' to '%s'\n"}`, args[0], args[1])
+		case "/api/v0/log/ls":
+			fmt.Fprint(w, `{"Strings":["dht","bitswap","swarm2"]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Source{Address: host, Port: port}, &set, server.Close
+}
+
+func TestParseLogLevels(t *testing.T) {
+	levels, err := ParseLogLevels([]string{"dht=DEBUG", "all=error"})
+	if err != nil || levels["dht"] != "debug" || levels["all"] != "error" {
+		t.Error(fmt.Sprintf("Invalid Levels: %v %v", levels, err))
+	}
+	if order := logLevelOrder(levels); order[0] != "all" || order[1] != "dht" {
+		t.Error(fmt.Sprintf("Invalid Order: %v", order))
+	}
+	for _, args := range [][]string{{"dht"}, {"=debug"}, {"dht=loud"}} {
+		if _, err := ParseLogLevels(args); err == nil {
+			t.Error(fmt.Sprintf("Valid Levels: %v", args))
+		}
+	}
+}
+
+func TestSetLogLevels(t *testing.T) {
+	source, set, stop := testLevelsAPI(t)
+	defer stop()
+	source.LogLevels = map[string]string{"dht": "debug", "*": "error"}
+	lp := &LogProxy{Source: source, ctx: context.Background()}
+	lp.applyLogLevels()
+	if strings.Join(*set, " ") != "all=error dht=debug" {
+		t.Fatal(fmt.Sprintf("Invalid Levels Set: %v", *set))
+	}
+	if err := lp.SetLogLevels(map[string]string{"bitswap": "info"}); err != nil {
+		t.Fatal(err)
+	}
+	if levels := lp.LogLevels(); len(levels) != 3 || levels["bitswap"] != "info" {
+		t.Error(fmt.Sprintf("Invalid Levels: %v", levels))
+	}
+	//setting every subsystem replaces the levels set before
+	if err := lp.SetLogLevels(map[string]string{"all": "warn"}); err != nil {
+		t.Fatal(err)
+	}
+	if levels := lp.LogLevels(); len(levels) != 1 || levels["all"] != "warn" {
+		t.Error(fmt.Sprintf("Invalid Levels: %v", levels))
+	}
+
+	subsystems, err := listSubsystems(context.Background(), source)
+	if err != nil || strings.Join(subsystems, ",") != "bitswap,dht,swarm2" {
+		t.Error(fmt.Sprintf("Invalid Subsystems: %v %v", subsystems, err))
+	}
+	var b bytes.Buffer
+	printLevels(&b, []LevelsResult{{Name: "QmA", Levels: map[string]string{"all": "warn", "dht": "debug"}, Subsystems: subsystems}})
+	if !strings.Contains(b.String(), "QmA   bitswap    warn") || !strings.Contains(b.String(), "QmA   dht        debug") {
+		t.Error(fmt.Sprintf("Invalid Table:\n%s", b.String()))
+	}
+}
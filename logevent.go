@@ -4,16 +4,90 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
 var messageFields = []string{"duration"}
-var messageTags = []string{"session", "subsystem", "event", "requestId"}
+var messageTags = []string{"session", "subsystem", "event", "requestId", "level"}
 
+//An event, with the keys every event has parsed from its message once when it is decoded.
+//The message is what is written as json, so the typed keys are changed with their setters.
+//The rest of the message is kept as it was decoded and converted when it is written,
+//ExtraFields only holds the line protocol fields the daemon adds, e.g. polled stats.
 type LogEvent struct {
-	Message map[string]interface{} `json:"message"`
-	Tags    []Tag                  `json:"tags"`
+	Time        time.Time              `json:"-"` //zero if the message has no valid time
+	System      string                 `json:"-"`
+	Event       string                 `json:"-"`
+	Duration    time.Duration          `json:"-"`
+	Message     map[string]interface{} `json:"message"`
+	Tags        []Tag                  `json:"tags"`
+	ExtraFields map[string]interface{} `json:"fields,omitempty"` //line protocol fields added by the daemon, not from the message
+	Raw         json.RawMessage        `json:"-"`                //the message as it was read, if it was decoded from one
+	update      *ProxyUpdate           //set on the marker carrying an update down the pipeline
+}
+
+//Returns an event of a message made in the daemon
+func NewLogEvent(message map[string]interface{}) LogEvent {
+	le := LogEvent{Message: message}
+	le.parse()
+	return le
+}
+
+//Returns the event of a raw go-log message
+func DecodeLogEvent(raw []byte) (LogEvent, error) {
+	var le LogEvent
+	if err := json.Unmarshal(raw, &le.Message); err != nil {
+		return le, err
+	}
+	if le.Message == nil {
+		return le, errors.New("Invalid Event: null")
+	}
+	le.Raw = raw
+	le.parse()
+	return le, nil
+}
+
+//Decodes an event as it is written by the json sink, tail and the spill queue
+func (le *LogEvent) UnmarshalJSON(b []byte) error {
+	type logEvent LogEvent //without this method
+	var e logEvent
+	if err := json.Unmarshal(b, &e); err != nil {
+		return err
+	}
+	*le = LogEvent(e)
+	le.parse()
+	return nil
+}
+
+//Parse the typed keys from the message, keys of the wrong type are left zero
+func (le *LogEvent) parse() {
+	le.System, _ = tagString(le.Message["system"])
+	le.Event, _ = tagString(le.Message["event"])
+	le.Time = time.Time{}
+	if ts, ok := le.Message["time"].(string); ok {
+		le.Time, _ = time.Parse(time.RFC3339Nano, ts)
+	}
+	le.Duration = 0
+	switch d := le.Message["duration"].(type) {
+	case float64:
+		le.Duration = time.Duration(d)
+	case json.Number:
+		f, _ := d.Float64()
+		le.Duration = time.Duration(f)
+	case int:
+		le.Duration = time.Duration(d)
+	case int64:
+		le.Duration = time.Duration(d)
+	}
+}
+
+//Set the time of the event and of its message
+func (le *LogEvent) SetTime(t time.Time) {
+	le.Time = t
+	le.Message["time"] = t.UTC().Format(time.RFC3339Nano)
 }
 
 func (le *LogEvent) AddTags(tags []Tag) {
@@ -45,14 +119,14 @@ func (le *LogEvent) ToJSON() ([]byte, error) {
 //swarm2,nodeId=QmcJ9RHiEoa1WYeaFAEHVgjc41aXfD52WDEFLZrEcQvbPR,event=swarmDialAttemptSync duration=1129297969.000000 1510956550080102777
 //Returns a log event in Line Protocol Format
 func (le *LogEvent) ToLP() ([]byte, error) {
-	//Duration is a field, and line protocol must have at least 1 field
-	//TODO: Replace in go-log
-	if le.Message["duration"] == nil {
-		le.Message["duration"] = 0
-	}
+	return le.ToFlatLP(nil)
+}
 
-	measurement := le.Message["system"]
-	fields, err := le.getLPFields()
+//Returns a log event in Line Protocol Format, with the rest of its message
+//flattened into fields, or left out if flatten is nil
+func (le *LogEvent) ToFlatLP(flatten *Flatten) ([]byte, error) {
+	measurement := le.System
+	fields, err := le.getLPFields(flatten)
 	if err != nil {
 		return nil, err
 	}
@@ -77,53 +151,108 @@ func (le *LogEvent) ToLP() ([]byte, error) {
 func (le *LogEvent) getLPTags() ([]string, error) {
 	var tags []string
 	for _, tag := range messageTags {
-		//if the messages contains the tag
-		if le.Message[tag] != nil && len(le.Message[tag].(string)) != 0 {
-			value := fmt.Sprintf("%s=%s", tag, le.Message[tag].(string))
+		//if the messages contains the tag, as a string or a number
+		if s, ok := tagString(le.Message[tag]); ok && len(s) != 0 {
+			value := fmt.Sprintf("%s=%s", tag, lpEscaper.Replace(s))
 			tags = append(tags, value)
 		}
 	}
 	for t := range le.Tags {
-		value := fmt.Sprintf("%s=%s", le.Tags[t].Name, le.Tags[t].Value)
+		//influxdb rejects tags without a value
+		if len(le.Tags[t].Value) == 0 {
+			continue
+		}
+		value := fmt.Sprintf("%s=%s", lpEscaper.Replace(le.Tags[t].Name), lpEscaper.Replace(le.Tags[t].Value))
 		tags = append(tags, value)
 	}
 	return tags, nil
 }
 
-func (le *LogEvent) getLPFields() ([]string, error) {
-	var fields []string
-	for _, field := range messageFields {
-		if le.Message[field] == nil {
-			continue
+//Escapes the characters which separate tags in line protocol, e.g. in prometheus labels
+var lpEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+
+//Escapes a string field value, which is quoted in line protocol
+var lpStringEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+//Returns the value of a tag as a string, false if it is not a scalar
+func tagString(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case float64:
+		//json numbers, so a requestId of 42 is 42 and not 42.000000
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), true
+	case json.Number:
+		return v.String(), true
+	case uint, uint8, uint16, uint32, uint64, int, int8, int16, int32, int64:
+		return fmt.Sprintf("%d", v), true
+	default:
+		return "", false
+	}
+}
+
+func (le *LogEvent) getLPFields(flatten *Flatten) ([]string, error) {
+	//Duration is a field, and line protocol must have at least 1 field
+	fields := []string{"duration=0"}
+	if le.Duration != 0 {
+		fields[0] = fmt.Sprintf("duration=%f", float64(le.Duration))
+	}
+	var names []string
+	for name := range le.ExtraFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		si, err := stringifyInterface(le.ExtraFields[name])
+		if err != nil {
+			return nil, err
 		}
-		si, err := stringifyInterface(le.Message[field])
+		fields = append(fields, fmt.Sprintf("%s=%s", lpEscaper.Replace(name), si))
+	}
+	if flatten == nil {
+		return fields, nil
+	}
+	flat := flatten.Message(le)
+	names = names[:0]
+	for name := range flat {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		si, err := stringifyInterface(flat[name])
 		if err != nil {
 			return nil, err
 		}
-		value := fmt.Sprintf("%s=%s", field, si)
-		fields = append(fields, value)
+		fields = append(fields, fmt.Sprintf("%s=%s", lpEscaper.Replace(name), si))
 	}
 	return fields, nil
 }
 
 func (le *LogEvent) getLPTime() (int64, error) {
-	t, err := time.Parse(time.RFC3339Nano, le.Message["time"].(string))
-	if err != nil {
-		return -1, err
+	if le.Time.IsZero() {
+		return -1, errors.New(fmt.Sprintf("Invalid Time: %#v", le.Message["time"]))
 	}
-	return t.UnixNano(), nil
+	return le.Time.UnixNano(), nil
 }
 
-//Bare minimum, needs work
-//TODO add more cases as they arrise
+//Returns a field value in line protocol format, an error for values which are not scalars
 func stringifyInterface(e interface{}) (string, error) {
-	switch e.(type) {
+	switch v := e.(type) {
 	case uint, uint8, uint16, uint32, uint64, int, int8, int16, int32, int64:
-		return fmt.Sprintf("%d", e), nil
-	case float64:
-		return fmt.Sprintf("%f", e), nil
+		return fmt.Sprintf("%d", v), nil
+	case float64, float32:
+		return fmt.Sprintf("%f", v), nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case string:
+		return `"` + lpStringEscaper.Replace(v) + `"`, nil
 	default:
-		errlog.Fatalf("Unknown Type: %#v", e)
 		return "", errors.New(fmt.Sprintf("Unknown Type: %#v", e))
 	}
 }
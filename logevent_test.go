@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+const nestedEvent = `{"system":"bitswap","event":"wantlist","time":"2017-11-17T22:09:10Z","session":7,"requestId":"r 1","peer":{"id":"QmB","addrs":["/ip4/1.2.3.4/tcp/4001","/ip4/5.6.7.8/tcp/4001"]},"cids":[{"cid":"Qm1"},"Qm2"],"ok":true,"note":"say \"hi\""}`
+
+func decodeEvent(t *testing.T, s string) LogEvent {
+	le, err := DecodeLogEvent([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return le
+}
+
+func TestLPNumericTags(t *testing.T) {
+	le := decodeEvent(t, nestedEvent)
+	b, err := le.ToLP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `bitswap,session=7,event=wantlist,requestId=r\ 1 duration=0 1510956550000000000`+"\n" {
+		t.Error(fmt.Sprintf("Invalid Line Protocol: %s", b))
+	}
+	//tags which are not scalars are left out rather than panicking
+	le.Message["session"] = map[string]interface{}{"id": 1.0}
+	if b, err = le.ToLP(); err != nil || string(b) != `bitswap,event=wantlist,requestId=r\ 1 duration=0 1510956550000000000`+"\n" {
+		t.Error(fmt.Sprintf("Invalid Line Protocol: %s %v", b, err))
+	}
+}
+
+func TestLPFlatten(t *testing.T) {
+	le := decodeEvent(t, nestedEvent)
+	b, err := le.ToFlatLP(&Flatten{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `bitswap,session=7,event=wantlist,requestId=r\ 1 duration=0,cids="{\"cid\":\"Qm1\"},Qm2",note="say \"hi\"",ok=true,peer.addrs="/ip4/1.2.3.4/tcp/4001,/ip4/5.6.7.8/tcp/4001",peer.id="QmB" 1510956550000000000` + "\n"
+	if string(b) != expected {
+		t.Error(fmt.Sprintf("Invalid Joined Line Protocol: %s", b))
+	}
+
+	b, err = le.ToFlatLP(&Flatten{Separator: "_", Arrays: FlattenExplode})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `bitswap,session=7,event=wantlist,requestId=r\ 1 duration=0,cids_0_cid="Qm1",cids_1="Qm2",note="say \"hi\"",ok=true,peer_addrs_0="/ip4/1.2.3.4/tcp/4001",peer_addrs_1="/ip4/5.6.7.8/tcp/4001",peer_id="QmB" 1510956550000000000` + "\n"
+	if string(b) != expected {
+		t.Error(fmt.Sprintf("Invalid Exploded Line Protocol: %s", b))
+	}
+}
+
+func TestLPInvalidEvent(t *testing.T) {
+	le := NewLogEvent(map[string]interface{}{"system": "dht", "event": "findPeer"})
+	if _, err := le.ToLP(); err == nil {
+		t.Error("Line Protocol without a time")
+	}
+	le = NewLogEvent(map[string]interface{}{"system": "dht", "time": "2017-11-17T22:09:10Z"})
+	le.ExtraFields = map[string]interface{}{"peers": []string{"QmA"}}
+	if _, err := le.ToLP(); err == nil {
+		t.Error("Line Protocol with an array field")
+	}
+}
+
+func TestDecodeLogEvent(t *testing.T) {
+	le := decodeEvent(t, `{"system":"swarm2","event":"swarmDialAttemptSync","time":"2017-11-17T22:09:10.08Z","duration":1129297969}`)
+	if le.System != "swarm2" || le.Event != "swarmDialAttemptSync" || le.Duration != 1129297969 || le.Time.UnixNano() != 1510956550080000000 || len(le.Raw) == 0 {
+		t.Error(fmt.Sprintf("Invalid Event: %+v", le))
+	}
+	//the keys are parsed again when an event written as json is read back
+	le.AddTag(MakeTag("nodeId", "QmA"))
+	b, err := le.ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var read LogEvent
+	if err := json.Unmarshal(b, &read); err != nil {
+		t.Fatal(err)
+	}
+	if read.System != le.System || read.Duration != le.Duration || !read.Time.Equal(le.Time) || len(read.Tags) != 1 {
+		t.Error(fmt.Sprintf("Invalid Read Event: %+v", read))
+	}
+	//keys of the wrong type are left zero
+	le = decodeEvent(t, `{"system":{"name":"dht"},"time":17,"duration":"1s"}`)
+	if le.System != "" || !le.Time.IsZero() || le.Duration != 0 {
+		t.Error(fmt.Sprintf("Invalid Event: %+v", le))
+	}
+	if _, err := DecodeLogEvent([]byte("null")); err == nil {
+		t.Error("Decoded a null event")
+	}
+}
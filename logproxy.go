@@ -4,15 +4,31 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StateRunning = "running"
+	StatePaused  = "paused"
+)
+
+const (
+	maxReconnectBackoff = 30 * time.Second
+	batchFlushInterval  = time.Second
 )
 
 type LogProxy struct {
+	Stats        ProxyStats //first so the counters are 64-bit aligned for sync/atomic
 	Name         string
+	NodeId       string
 	Source       Source
 	Sink         Sink
 	sourceStream io.ReadCloser
@@ -21,129 +37,508 @@ type LogProxy struct {
 	Outbound     chan LogEvent
 	ctx          context.Context
 	cancel       func()
-	Filters      []func(LogEvent) LogEvent
+	stopRead     chan struct{} //closed to stop reading and drain the pipeline
+	readerDone   chan struct{} //closed once the reader and poller stopped
+	readers      sync.WaitGroup
+	filterDone   chan struct{}
+	writerDone   chan struct{}
+	mu           sync.RWMutex  //guards Source, Sink, sourceStream, resume and recorder once the proxy is started
+	resume       chan struct{} //non nil while paused, closed on resume
+	batch        bytes.Buffer  //encoded events waiting to be written, owned by WriteSink
+	batchLen     int
+	inSpill      *spillQueue //only set with the spill backpressure policy
+	outSpill     *spillQueue
+	updating     int32     //updates in flight, only accessed through sync/atomic
+	recorder     *Recorder //set while the raw events are recorded
+	skew         clockSkew //estimated clock offset of the node
+}
+
+//Changes applied to a running proxy, nil fields are left as they are
+type ProxyUpdate struct {
+	Tags    []Tag
+	Filters []Filter
+	Sink    *Sink
+	done    chan error
 }
 
 //Start a log proxy
 func (lp *LogProxy) Start() {
 	lp.ctx, lp.cancel = context.WithCancel(context.Background())
+	lp.stopRead = make(chan struct{})
+	lp.readerDone = make(chan struct{})
+	lp.filterDone = make(chan struct{})
+	lp.writerDone = make(chan struct{})
 
-	sourceUrl := GetIpfsLogAddress(lp.Source)
-	resp, err := http.Get(sourceUrl)
-	if err != nil {
-		errlog.Println("Get log stream: ", err)
+	//prometheus sources are scraped and replays read a file, they have no log stream
+	var replay *ReplayReader
+	if lp.Source.isReplay() {
+		var err error
+		if replay, err = OpenReplay(lp.Source.File); err != nil {
+			errlog.Println("Open replay: ", err)
+			return
+		}
+		replay.LogFormat = lp.Source.LogFormat
+	} else if !lp.Source.isPrometheus() {
+		if err := lp.connect(); err != nil {
+			errlog.Println("Get log stream: ", err)
+			return
+		}
+	}
+	if err := lp.openSpill(); err != nil {
+		errlog.Println("Open spill queue: ", err)
+		lp.closeSource()
+		if replay != nil {
+			replay.Close()
+		}
 		return
 	}
-	lp.sourceStream = resp.Body
 
-	//If we are not writing to stdout, and the format is lineprotocol
-	//we are probably writing to influxdb, so ensure the db exists
-	if len(lp.Sink.Address) != 0 && lp.Sink.Format == "lineprotocol" {
-		_, err := CreateDatabase(db, lp.Sink)
-		if err != nil {
-			errlog.Println("Failed to create database: ", err)
-			panic("Please ensure that influxdb is running")
+	if len(lp.Source.Record) != 0 {
+		if _, err := lp.StartRecording(lp.Source.Record); err != nil {
+			errlog.Println("Start recording: ", err)
 		}
-		infolog.Print("database found!")
+	}
+
+	if err := ensureDatabase(lp.Sink); err != nil {
+		errlog.Println("Failed to create database: ", err)
+		panic("Please ensure that influxdb is running")
 	}
 
 	infolog.Printf("Opening Connection Name: %s\n", lp.Name)
-	go lp.ReadSource()
+	lp.readers.Add(1)
+	if lp.Source.isReplay() {
+		go lp.ReplayEvents(replay)
+	} else if lp.Source.isPrometheus() {
+		go lp.ScrapeMetrics(lp.Source.scrapeInterval())
+	} else {
+		go lp.ReadSource()
+	}
+	if interval := lp.Source.pollInterval(); interval > 0 {
+		lp.readers.Add(1)
+		go lp.PollStats(interval)
+	}
+	go func() {
+		lp.readers.Wait()
+		close(lp.readerDone)
+	}()
 	go lp.FilterEvents()
 	go lp.WriteSink()
 	//List use to keep track of active collections
-	proxyList[lp.Name] = lp
+	addProxy(lp)
+	if lp.Source.isReplay() {
+		go lp.finishReplay()
+	}
+}
+
+//Returns a copy of the source, which is changed under the lock by updates
+//and when log levels are set
+func (lp *LogProxy) source() Source {
+	lp.mu.RLock()
+	defer lp.mu.RUnlock()
+	return lp.Source
 }
 
 //Read from the source -> Filter
 func (lp *LogProxy) ReadSource() {
-	infolog.Printf("Reader Open In-Stream: %s Name: %s\n", lp.Source, lp.Name)
+	defer lp.readers.Done()
+	infolog.Printf("Reader Open In-Stream: %s Name: %s\n", lp.source(), lp.Name)
 	dec := json.NewDecoder(lp.sourceStream)
 	for {
 		select {
 		case <-lp.ctx.Done():
-			infolog.Printf("Reader Close In-Stream: %s Name: %s\n", lp.Source, lp.Name)
-			lp.sourceStream.Close()
+			infolog.Printf("Reader Close In-Stream: %s Name: %s\n", lp.source(), lp.Name)
+			lp.closeSource()
+			return
+		case <-lp.stopRead:
+			infolog.Printf("Reader Close In-Stream: %s Name: %s\n", lp.source(), lp.Name)
+			lp.closeSource()
 			return
 		default:
+			if !lp.waitResume() {
+				continue
+			}
+			//the raw event is kept for recording
+			var raw json.RawMessage
+			err := dec.Decode(&raw)
 			var event LogEvent
-			if err := dec.Decode(&event.Message); err != nil {
-				errlog.Printf("Read Source: %s decode error: %v", lp.Source, err)
-				return
+			if err == nil {
+				event, err = DecodeLogEvent(raw)
+			}
+			if err == nil {
+				event.normalize(lp.Source.LogFormat)
 			}
-			lp.Inbound <- event
+			if err != nil {
+				if !lp.reading() {
+					continue
+				}
+				errlog.Printf("Read Source: %s decode error: %v", lp.source(), err)
+				lp.closeSource()
+				if lp.reconnect() {
+					dec = json.NewDecoder(lp.sourceStream)
+				}
+				continue
+			}
+			received := time.Now()
+			lp.record(raw, received)
+			lp.adjustClock(&event, received)
+			lp.Stats.read()
+			lp.push(lp.Inbound, lp.inSpill, event)
 		}
 	}
 
 }
 
+//Returns false once the proxy is closed or stopped reading
+func (lp *LogProxy) reading() bool {
+	select {
+	case <-lp.ctx.Done():
+		return false
+	case <-lp.stopRead:
+		return false
+	default:
+		return true
+	}
+}
+
+//Open the log stream of the source
+func (lp *LogProxy) connect() error {
+	resp, err := postIpfsAPI(lp.ctx, lp.source(), ipfsLogTailPath)
+	if err != nil {
+		return err
+	}
+	lp.mu.Lock()
+	lp.sourceStream = resp.Body
+	lp.mu.Unlock()
+	lp.applyLogLevels()
+	return nil
+}
+
+//Close the log stream of the source, which also unblocks a pending read
+func (lp *LogProxy) closeSource() {
+	lp.mu.RLock()
+	defer lp.mu.RUnlock()
+	if lp.sourceStream != nil {
+		lp.sourceStream.Close()
+	}
+}
+
+//Reopen the log stream of the source, backing off between attempts,
+//returns false if the proxy was closed first
+func (lp *LogProxy) reconnect() bool {
+	backoff := time.Second
+	for {
+		select {
+		case <-lp.ctx.Done():
+			return false
+		case <-lp.stopRead:
+			return false
+		case <-time.After(backoff):
+		}
+		lp.Stats.reconnect()
+		infolog.Printf("Reconnecting In-Stream: %s Name: %s\n", lp.source(), lp.Name)
+		err := lp.connect()
+		if err == nil {
+			return true
+		}
+		errlog.Printf("Reconnect Source: %s error: %v", lp.source(), err)
+		if backoff < maxReconnectBackoff {
+			backoff *= 2
+		}
+	}
+}
+
 //Apply filters to event
 func (lp *LogProxy) FilterEvents() {
-	infolog.Printf("Filter Open In-Stream: %s Name: %s\n", lp.Source, lp.Name)
+	defer close(lp.filterDone)
+	infolog.Printf("Filter Open In-Stream: %s Name: %s\n", lp.source(), lp.Name)
 	for {
 		select {
 		case <-lp.ctx.Done():
-			infolog.Printf("Filter Close In-Stream: %s Name: %s\n", lp.Source, lp.Name)
+			infolog.Printf("Filter Close In-Stream: %s Name: %s\n", lp.source(), lp.Name)
 			return
 		case event := <-lp.Inbound:
-			//for _, filter := range lp.Filters {
-			//	event = filter(event)
-			//}
-			event.AddTags(lp.Source.Tags)
-			lp.Outbound <- event
+			lp.filterEvent(event)
+		case <-lp.readerDone:
+			//nothing new will be read, pass on what is left then stop
+			lp.drain(lp.Inbound, lp.inSpill, lp.filterEvent)
+			infolog.Printf("Filter Close In-Stream: %s Name: %s\n", lp.source(), lp.Name)
+			return
 		}
 	}
 }
 
+func (lp *LogProxy) filterEvent(event LogEvent) {
+	if event.update != nil {
+		lp.applySourceUpdate(event.update)
+		lp.push(lp.Outbound, lp.outSpill, event)
+		return
+	}
+	if !PassFilters(lp.Source.Filters, event) {
+		lp.Stats.filtered()
+		return
+	}
+	event.AddTags(lp.Source.Tags)
+	tailEvents.publish(lp.Name, event)
+	correlate(lp.NodeId, event)
+	lp.push(lp.Outbound, lp.outSpill, event)
+}
+
 //Write log events to sink
 func (lp *LogProxy) WriteSink() {
+	defer close(lp.writerDone)
 	infolog.Printf("Writer Open Out-Stream: %s Name: %s\n", lp.Sink, lp.Name)
+	flush := time.NewTicker(batchFlushInterval)
+	defer flush.Stop()
 	for {
 		select {
 		case event := <-lp.Outbound:
-			var b []byte
-			var err error
-			if lp.Sink.Format == "lineprotocol" {
-				b, err = event.ToLP()
-				if err != nil {
-					errlog.Println("Write Sink marshal: ", err)
-					continue
-				}
-			} else {
-				b, err = event.ToJSON()
-				if err != nil {
-					errlog.Println("Write Sink marshal: ", err)
-					continue
-				}
+			lp.writeEvent(event)
+		case <-flush.C:
+			lp.flushBatch()
+		case <-lp.filterDone:
+			//nothing new will be filtered, write what is left then stop
+			lp.drain(lp.Outbound, lp.outSpill, lp.writeEvent)
+			lp.flushBatch()
+			infolog.Printf("Writer Close Out-Stream: %s Name: %s\n", lp.Sink, lp.Name)
+			return
+		case <-lp.ctx.Done():
+			//the proxy was removed, write the events already batched or waiting
+			for len(lp.Outbound) != 0 {
+				lp.writeEvent(<-lp.Outbound)
 			}
-			if len(lp.Sink.Address) == 0 {
-				os.Stdout.Write(b)
-			} else {
-				url := fmt.Sprintf("http://%s/write?db=%s", lp.Sink, db)
-				resp, err := http.Post(url, "application/octet-stream", bytes.NewBuffer(b))
-				if err != nil {
-					errlog.Printf("Did you forget to include the port? Inlfux is usualy on 8086")
-					panic(err)
-				}
-				defer resp.Body.Close()
-				if resp.StatusCode != 204 {
-					errlog.Printf("Write Sink write: %#v", event)
-					errlog.Println("Status: ", resp.Status)
-					errlog.Println("Headers: ", resp.Header)
-					body, _ := ioutil.ReadAll(resp.Body)
-					errlog.Println("Body: ", string(body))
-				}
+			lp.flushBatch()
+			infolog.Printf("Writer Close Out-Stream: %s Name: %s\n", lp.Sink, lp.Name)
+			return
+		}
+	}
+}
 
-			}
+func (lp *LogProxy) writeEvent(event LogEvent) {
+	if event.update != nil {
+		lp.flushBatch()
+		lp.applySinkUpdate(event.update)
+		return
+	}
+	lp.batchEvent(event)
+}
 
+//Handle every event left in a channel and its spill queue,
+//until they are empty or the proxy is closed
+func (lp *LogProxy) drain(ch chan LogEvent, spill *spillQueue, handle func(LogEvent)) {
+	for len(ch) != 0 || (spill != nil && spill.Len() != 0) {
+		select {
+		case event := <-ch:
+			handle(event)
 		case <-lp.ctx.Done():
-			infolog.Printf("Writer Close Out-Stream: %s Name: %s\n", lp.Sink, lp.Name)
 			return
+		case <-time.After(10 * time.Millisecond):
+			//the spill queue is handing over its next event
+		}
+	}
+}
+
+//Encode an event in the format of the sink, node names the process of a trace
+//if the event has no node id
+func encodeEvent(sink Sink, event LogEvent, node string) ([]byte, error) {
+	switch sink.Format {
+	case "lineprotocol":
+		return event.ToFlatLP(sink.Flatten)
+	case "trace":
+		return encodeTrace(sink, event, node)
+	default:
+		return event.ToJSON()
+	}
+}
+
+//Encode an event into the batch, writing the batch once it is full
+func (lp *LogProxy) batchEvent(event LogEvent) {
+	b, err := encodeEvent(lp.Sink, event, lp.NodeId)
+	if err != nil {
+		errlog.Println("Write Sink marshal: ", err)
+		lp.Stats.encodeError()
+		return
+	}
+	lp.batch.Write(b)
+	lp.batchLen++
+	if lp.batchLen >= lp.Sink.batchSize() {
+		lp.flushBatch()
+	}
+}
+
+func (lp *LogProxy) flushBatch() {
+	if lp.batchLen == 0 {
+		return
+	}
+	n, size := lp.batchLen, lp.batch.Len()
+	start := time.Now()
+	err := writeSink(lp.Sink, lp.batch.Bytes())
+	lp.batch.Reset()
+	lp.batchLen = 0
+	if err != nil {
+		errlog.Printf("Write Sink: %s write: %v", lp.Sink, err)
+		lp.Stats.sinkError(n)
+		return
+	}
+	lp.Stats.written(n, size, time.Since(start))
+}
+
+//Write encoded events to stdout, or post them to the sinks http endpoint
+func writeSink(sink Sink, b []byte) error {
+	if len(sink.File) != 0 {
+		sf, err := openSinkFile(sink)
+		if err != nil {
+			return err
 		}
+		return sf.Write(b)
+	}
+	if len(sink.Address) == 0 {
+		_, err := os.Stdout.Write(b)
+		return err
+	}
+	url := fmt.Sprintf("http://%s/write?db=%s", sink, db)
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	sink.setAuth(req)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		errlog.Printf("Did you forget to include the port? Inlfux is usualy on 8086")
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 204 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return errors.New(fmt.Sprintf("Status: %s Body: %s", resp.Status, string(body)))
 	}
+	return nil
 }
 
 func (lp *LogProxy) Close() {
 	infolog.Printf("Closing Connection Name: %s\n", lp.Name)
 	lp.cancel()
+	lp.closeSource()
+	if lp.inSpill != nil {
+		lp.inSpill.Close()
+		lp.outSpill.Close()
+	}
+	lp.mu.RLock()
+	recording := lp.recorder != nil
+	lp.mu.RUnlock()
+	if recording {
+		lp.StopRecording()
+	}
+}
+
+func (lp *LogProxy) State() string {
+	lp.mu.RLock()
+	defer lp.mu.RUnlock()
+	if lp.resume != nil {
+		return StatePaused
+	}
+	return StateRunning
+}
+
+//Stop reading from the source, the connection to the source is kept open
+func (lp *LogProxy) Pause() error {
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+	if lp.resume != nil {
+		return errors.New(fmt.Sprintf("ERROR - Source: %s already paused", lp.Name))
+	}
+	infolog.Printf("Pausing Connection Name: %s\n", lp.Name)
+	lp.resume = make(chan struct{})
+	return nil
+}
+
+//Continue reading from the source
+func (lp *LogProxy) Resume() error {
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+	if lp.resume == nil {
+		return errors.New(fmt.Sprintf("ERROR - Source: %s is not paused", lp.Name))
+	}
+	infolog.Printf("Resuming Connection Name: %s\n", lp.Name)
+	close(lp.resume)
+	lp.resume = nil
+	return nil
+}
+
+//Blocks while the proxy is paused, returns false if the proxy was closed
+func (lp *LogProxy) waitResume() bool {
+	lp.mu.RLock()
+	resume := lp.resume
+	lp.mu.RUnlock()
+	if resume == nil {
+		return true
+	}
+	select {
+	case <-resume:
+		return true
+	case <-lp.ctx.Done():
+		return false
+	case <-lp.stopRead:
+		return false
+	}
+}
+
+//Swap the tags, filters or sink of a running proxy. The update is passed
+//down the pipeline behind the events already read, so those are filtered
+//and written with the old settings before the new ones take over.
+func (lp *LogProxy) Update(u ProxyUpdate) error {
+	u.done = make(chan error, 1)
+	atomic.AddInt32(&lp.updating, 1)
+	defer atomic.AddInt32(&lp.updating, -1)
+	lp.push(lp.Inbound, lp.inSpill, LogEvent{update: &u})
+	select {
+	case err := <-u.done:
+		if err != nil {
+			return err
+		}
+		infolog.Printf("Updated Connection Name: %s\n", lp.Name)
+		return nil
+	case <-lp.ctx.Done():
+		return errors.New(fmt.Sprintf("ERROR - Source: %s closed before update", lp.Name))
+	}
+}
+
+func (lp *LogProxy) applySourceUpdate(u *ProxyUpdate) {
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+	if u.Tags != nil {
+		//the nodeId tag always identifies the source
+		lp.Source.Tags = append(u.Tags, MakeTag("nodeId", lp.NodeId))
+	}
+	if u.Filters != nil {
+		lp.Source.Filters = u.Filters
+	}
+}
+
+func (lp *LogProxy) applySinkUpdate(u *ProxyUpdate) {
+	if u.Sink == nil {
+		u.done <- nil
+		return
+	}
+	if err := ensureDatabase(*u.Sink); err != nil {
+		u.done <- errors.New(fmt.Sprintf("ERROR - Sink: %s failed to create database: %v", u.Sink, err))
+		return
+	}
+	lp.mu.Lock()
+	lp.Sink = *u.Sink
+	lp.mu.Unlock()
+	u.done <- nil
+}
+
+//If we are not writing to stdout, and the format is lineprotocol
+//we are probably writing to influxdb, so ensure the db exists
+func ensureDatabase(sink Sink) error {
+	if len(sink.Address) == 0 || sink.Format != "lineprotocol" {
+		return nil
+	}
+	if _, err := CreateDatabase(db, sink); err != nil {
+		return err
+	}
+	infolog.Print("database found!")
+	return nil
 }
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//A node whose log stream sends the events handed to it
+type testNode struct {
+	Source Source
+	events chan string
+}
+
+func newTestNode(t *testing.T) *testNode {
+	node := &testNode{events: make(chan string, 16)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v0/id":
+			fmt.Fprint(w, `{"ID":"QmNode"}`)
+		case "/api/v0/log/tail":
+			w.(http.Flusher).Flush()
+			for {
+				select {
+				case event := <-node.events:
+					fmt.Fprintln(w, event)
+					w.(http.Flusher).Flush()
+				case <-r.Context().Done():
+					return
+				}
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(func() {
+		server.CloseClientConnections()
+		server.Close()
+	})
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.Source = Source{Address: host, Port: port}
+	return node
+}
+
+func testNodeEvent(i int) string {
+	return fmt.Sprintf(`{"system":"dht","event":"findPeer","time":"2017-11-17T22:09:10Z","requestId":%d}`, i)
+}
+
+//Start a proxy of the node, it is shut down when the test ends
+func startTestProxy(t *testing.T, source Source, sink Sink) *LogProxy {
+	lp := &LogProxy{
+		Name:     "QmNode",
+		NodeId:   "QmNode",
+		Source:   source,
+		Sink:     sink,
+		Inbound:  make(chan LogEvent, source.bufferSize()),
+		Outbound: make(chan LogEvent, source.bufferSize()),
+	}
+	lp.Start()
+	if lp.ctx.Err() != nil || getProxy(lp.Name) != lp {
+		t.Fatal("Proxy not started")
+	}
+	t.Cleanup(func() {
+		if removeProxy(lp) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			lp.Shutdown(ctx)
+		}
+		closeSinkFiles()
+	})
+	return lp
+}
+
+func waitFor(t *testing.T, what string, done func() bool) {
+	for start := time.Now(); !done(); time.Sleep(5 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal(fmt.Sprintf("Timed out waiting for %s", what))
+		}
+	}
+}
+
+func eventsRead(lp *LogProxy) func() uint64 {
+	return func() uint64 {
+		return atomic.LoadUint64(&lp.Stats.eventsRead)
+	}
+}
+
+//Events written to a json sink file
+func readSinkEvents(t *testing.T, path string) []LogEvent {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var events []LogEvent
+	dec := json.NewDecoder(file)
+	for {
+		var le LogEvent
+		if err := dec.Decode(&le); err == io.EOF {
+			return events
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, le)
+	}
+}
+
+func hasTestTag(le LogEvent) bool {
+	return le.hasTag(MakeTag("env", "new"))
+}
+
+func TestUpdateInOrder(t *testing.T) {
+	node := newTestNode(t)
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "first.json"), filepath.Join(dir, "second.json")
+	lp := startTestProxy(t, node.Source, Sink{Format: "json", File: first})
+	node.events <- testNodeEvent(1)
+	node.events <- testNodeEvent(2)
+	read := eventsRead(lp)
+	waitFor(t, "events", func() bool { return read() == 2 })
+
+	//events read before the update keep the old tags and sink
+	err := lp.Update(ProxyUpdate{Tags: []Tag{MakeTag("env", "new")}, Sink: &Sink{Format: "json", File: second}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.events <- testNodeEvent(3)
+	waitFor(t, "events", func() bool { return read() == 3 })
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	removeProxy(lp)
+	lp.Shutdown(ctx)
+
+	old := readSinkEvents(t, first)
+	if len(old) != 2 || hasTestTag(old[0]) || hasTestTag(old[1]) || old[1].Message["requestId"] != 2.0 {
+		t.Error(fmt.Sprintf("Invalid Events Before Update: %v", old))
+	}
+	updated := readSinkEvents(t, second)
+	if len(updated) != 1 || !hasTestTag(updated[0]) || !updated[0].hasTag(MakeTag("nodeId", "QmNode")) || updated[0].Message["requestId"] != 3.0 {
+		t.Error(fmt.Sprintf("Invalid Events After Update: %v", updated))
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	node := newTestNode(t)
+	lp := startTestProxy(t, node.Source, Sink{Format: "json", File: filepath.Join(t.TempDir(), "events.json")})
+	read := eventsRead(lp)
+	node.events <- testNodeEvent(1)
+	waitFor(t, "event", func() bool { return read() == 1 })
+
+	if err := lp.Pause(); err != nil || lp.State() != StatePaused {
+		t.Fatal(fmt.Sprintf("Not Paused: %s %v", lp.State(), err))
+	}
+	if err := lp.Pause(); err == nil {
+		t.Error("Paused twice")
+	}
+	//the read already waiting for an event may finish, no more follow
+	node.events <- testNodeEvent(2)
+	node.events <- testNodeEvent(3)
+	time.Sleep(100 * time.Millisecond)
+	if n := read(); n == 3 {
+		t.Error(fmt.Sprintf("Read While Paused: %d", n))
+	}
+	if err := lp.Resume(); err != nil || lp.State() != StateRunning {
+		t.Fatal(fmt.Sprintf("Not Resumed: %s %v", lp.State(), err))
+	}
+	if err := lp.Resume(); err == nil {
+		t.Error("Resumed twice")
+	}
+	waitFor(t, "events after resume", func() bool { return read() == 3 })
+}
+
+func TestWriteSinkRemoved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.json")
+	defer closeSinkFiles()
+	ctx, cancel := context.WithCancel(context.Background())
+	lp := &LogProxy{
+		Name:       "QmA",
+		Sink:       Sink{Format: "json", File: path},
+		Outbound:   make(chan LogEvent, 4),
+		writerDone: make(chan struct{}),
+		ctx:        ctx,
+	}
+	event := NewLogEvent(map[string]interface{}{"system": "dht", "event": "findPeer", "time": "2017-11-17T22:09:10Z"})
+	lp.batchEvent(event)
+	lp.Outbound <- event
+	lp.Outbound <- event
+	//removing the proxy writes what was batched and what is waiting
+	cancel()
+	lp.WriteSink()
+	closeSinkFiles()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(b), `"event":"findPeer"`); n != 3 {
+		t.Error(fmt.Sprintf("Invalid Events Written: %d", n))
+	}
+}
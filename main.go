@@ -1,43 +1,108 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	cli "github.com/codegangsta/cli"
 )
 
 var infolog, errlog *log.Logger
-var port, db string
+var db string
 var proxyList = make(map[string]*LogProxy)
+var proxyLock sync.RWMutex //guards proxyList
 
 type Command struct {
-	Type     string              `json:"type"`     //add, remove, list
-	Node     string              `json:"node"`     //the name of the node the command it for
-	Source   []Source            `json:"source"`   //source of the log messages
-	Sink     Sink                `json:"sink"`     //sink where the log messages will flow
-	Result   string              `json:"result"`   //result of command - success or error message
-	Response http.ResponseWriter `json:"response"` //where the result of the command will be written
+	Type        string              `json:"type"`        //add, remove, list, stats, levels, pause, resume, update, discover, correlate, record, stop-record
+	Node        string              `json:"node"`        //the name of the node the command it for
+	Source      []Source            `json:"source"`      //source of the log messages
+	Sink        Sink                `json:"sink"`        //sink where the log messages will flow
+	Tags        []Tag               `json:"tags"`        //replacement tags for update
+	Filters     []Filter            `json:"filters"`     //replacement filters for update
+	Discovery   *Discovery          `json:"discovery"`   //sources to discover, nil stops discovery
+	Correlation *Correlation        `json:"correlation"` //how to correlate events, nil stops correlation
+	Record      string              `json:"record"`      //archive to record to, the daemon picks one if empty
+	LogLevels   map[string]string   `json:"logLevels"`   //levels to set, by subsystem
+	Subsystems  bool                `json:"subsystems"`  //list the logging subsystems of the nodes
+	Result      string              `json:"result"`      //result of command - success or error message
+	Response    http.ResponseWriter `json:"response"`    //where the result of the command will be written
 }
 
 func init() {
 	infolog = log.New(os.Stderr, "INFO - ", log.Ldate|log.Ltime)
 	errlog = log.New(os.Stderr, "ERROR - ", log.Ldate|log.Ltime)
-	port = ":9123"
 	db = "ipfsmetrics"
 }
 
 func main() {
 	app := cli.NewApp()
 	app.Usage = "ipfs-metrics is a tool for working with ipfs events"
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "api",
+			Value: defaultAPIAddress,
+			Usage: "Address of ipfs-metricsd: host:port, unix:path, a multiaddr or https://host:port",
+		},
+		cli.StringFlag{
+			Name:   "token",
+			Usage:  "Bearer token used to authenticate to ipfs-metricsd",
+			EnvVar: "IPFS_METRICS_TOKEN",
+		},
+		cli.StringFlag{
+			Name:  "tls-ca",
+			Usage: "CA certificate used to verify ipfs-metricsd",
+		},
+		cli.StringFlag{
+			Name:  "tls-cert",
+			Usage: "Client certificate presented to ipfs-metricsd",
+		},
+		cli.StringFlag{
+			Name:  "tls-key",
+			Usage: "Key of the client certificate",
+		},
+	}
+	app.Before = func(c *cli.Context) error {
+		config, err := clientTLSConfig(c.String("tls-ca"), c.String("tls-cert"), c.String("tls-key"))
+		if err != nil {
+			return err
+		}
+		api = &APIClient{
+			Address: c.String("api"),
+			Token:   c.String("token"),
+			TLS:     config,
+		}
+		return nil
+	}
 	app.Commands = []cli.Command{
 		startCmd,
 		addCmd,
+		discoverCmd,
+		correlateCmd,
 		rmCmd,
 		listCmd,
+		statsCmd,
+		levelsCmd,
+		tailCmd,
+		topCmd,
+		recordCmd,
+		replayCmd,
+		analyzeCmd,
+		traceCmd,
+		pauseCmd,
+		resumeCmd,
+		updateCmd,
+		configCmd,
 	}
 	err := app.Run(os.Args)
 	if err != nil {
@@ -52,7 +117,11 @@ var addCmd = cli.Command{
 	Flags: []cli.Flag{
 		cli.StringFlag{
 			Name:  "input, i",
-			Usage: "Input of the event logs",
+			Usage: "Input of the event logs: ip:port or a multiaddr like /ip4/127.0.0.1/tcp/5001",
+		},
+		cli.StringFlag{
+			Name:  "repo",
+			Usage: "Without --input, add the daemon of this ipfs repo (default $IPFS_PATH or ~/.ipfs)",
 		},
 		cli.StringFlag{
 			Name:  "output, o",
@@ -62,15 +131,84 @@ var addCmd = cli.Command{
 			Name:  "lineprotocol, lp",
 			Usage: "Use Line Protocol Format (Influxdb) when writing to output instead of json",
 		},
+		cli.BoolFlag{
+			Name:  "flatten",
+			Usage: "Write the nested objects and arrays of the events as line protocol fields",
+		},
+		cli.StringFlag{
+			Name:  "separator",
+			Usage: "Joins the keys of nested objects into field names when flattening (default .)",
+		},
+		cli.StringFlag{
+			Name:  "arrays",
+			Usage: "Flatten arrays by joining their elements into one field, or explode them into a field per element: join or explode (default join)",
+		},
+		cli.StringFlag{
+			Name:  "array-separator",
+			Usage: "Joins the elements of arrays when flattening (default ,)",
+		},
+		cli.StringFlag{
+			Name:  "trace",
+			Usage: "Write spans in the Chrome trace format to this file, on the disk of ipfs-metricsd, instead of an output",
+		},
 		cli.StringFlag{
 			Name:  "config, c",
-			Usage: "Specify a configuration file to use",
+			Usage: "Specify a configuration file to use, json, yaml or toml",
+		},
+		cli.StringSliceFlag{
+			Name:  "filter, f",
+			Usage: "Only keep events matching field=value, or drop events matching field!=value",
+		},
+		cli.IntFlag{
+			Name:  "batch-size",
+			Usage: "Number of events written to the output at once",
+		},
+		cli.IntFlag{
+			Name:  "buffer-size",
+			Usage: "Number of events buffered between each stage of collection (default 64)",
+		},
+		cli.StringFlag{
+			Name:  "backpressure",
+			Usage: "What to do when the output can not keep up: block, drop-newest, drop-oldest or spill (default block)",
+		},
+		cli.StringFlag{
+			Name:  "spill-dir",
+			Usage: "Directory holding events spilled to disk by the spill backpressure policy",
+		},
+		cli.DurationFlag{
+			Name:  "poll-interval",
+			Usage: "How often to poll the bandwidth, repo, peer and bitswap stats of the node (default never)",
+		},
+		cli.StringFlag{
+			Name:  "record",
+			Usage: "Record the raw events to this archive, on the disk of ipfs-metricsd",
+		},
+		cli.StringFlag{
+			Name:  "log-format",
+			Usage: "Format of the event log of the node: auto, eventlog for go-log v1 or zap for go-log v2 (default auto)",
+		},
+		cli.StringSliceFlag{
+			Name:  "log-level",
+			Usage: "Set the log level of a subsystem of the node when it is connected, subsystem=level, all=level for every subsystem",
+		},
+		cli.StringFlag{
+			Name:  "clock-skew",
+			Usage: "What to do with the estimated clock offset of the node: estimate, annotate or correct (default estimate)",
+		},
+		cli.BoolFlag{
+			Name:  "prometheus",
+			Usage: "Scrape the prometheus metrics of the node instead of tailing its event log",
+		},
+		cli.DurationFlag{
+			Name:  "scrape-interval",
+			Value: defaultScrapeInterval,
+			Usage: "How often to scrape the prometheus metrics",
 		},
 	},
 	Action: func(c *cli.Context) error {
 		showUsage := func(w io.Writer) {
-			fmt.Fprintln(w, "ipfs-metrics add -i [ip:port] -o [ip:port] [tagKey1=tagValue1...tagKeyn=tagValuen]\n")
-			fmt.Fprintln(w, "ipfs-metrics add --config [configFile]\n")
+			fmt.Fprint(w, "ipfs-metrics add -i [ip:port|multiaddr] -o [ip:port] [tagKey1=tagValue1...tagKeyn=tagValuen]\n\n")
+			fmt.Fprint(w, "ipfs-metrics add --config [configFile]\n\n")
 		}
 		cmd, err := NewAddCommand(c)
 		if err != nil {
@@ -79,7 +217,93 @@ var addCmd = cli.Command{
 		}
 		resp, err := SendCommand(cmd)
 		if err != nil {
-			errlog.Fatal("Please run `ipfs-metrics start` first")
+			exitSendError(err)
+			os.Exit(1)
+		}
+		io.Copy(os.Stdout, resp.Body)
+		return nil
+	},
+}
+
+var discoverCmd = cli.Command{
+	Name:      "discover",
+	Usage:     "automatically add and remove the ipfs daemons of a network",
+	ArgsUsage: "[tagKey1=tagValue1...tagKeyn=tagValuen]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "seed",
+			Usage: "Api of a node whose swarm peers are added: ip:port or a multiaddr",
+		},
+		cli.StringFlag{
+			Name:  "api-port",
+			Usage: "Api port of the swarm peers of the seed (default 5001)",
+		},
+		cli.StringSliceFlag{
+			Name:  "static",
+			Usage: "Api of a node to add: ip:port or a multiaddr",
+		},
+		cli.StringFlag{
+			Name:  "srv",
+			Usage: "DNS name with SRV records of node apis, e.g. _ipfs-api._tcp.testnet.example",
+		},
+		cli.StringFlag{
+			Name:  "repos",
+			Usage: "Glob of ipfs repo directories whose daemons are added, e.g. '/srv/testnet/*'",
+		},
+		cli.DurationFlag{
+			Name:  "interval",
+			Value: defaultDiscoveryInterval,
+			Usage: "How often to look for nodes",
+		},
+		cli.IntFlag{
+			Name:  "max-nodes",
+			Usage: "Most nodes discovery adds (default unlimited)",
+		},
+		cli.StringFlag{
+			Name:  "output, o",
+			Usage: "Output to which the event logs will flow (if empty will use stdout)",
+		},
+		cli.BoolFlag{
+			Name:  "lineprotocol, lp",
+			Usage: "Use Line Protocol Format (Influxdb) when writing to output instead of json",
+		},
+		cli.IntFlag{
+			Name:  "batch-size",
+			Usage: "Number of events written to the output at once",
+		},
+		cli.StringSliceFlag{
+			Name:  "filter, f",
+			Usage: "Only keep events matching field=value, or drop events matching field!=value",
+		},
+		cli.IntFlag{
+			Name:  "buffer-size",
+			Usage: "Number of events buffered between each stage of collection (default 64)",
+		},
+		cli.StringFlag{
+			Name:  "backpressure",
+			Usage: "What to do when the output can not keep up: block, drop-newest, drop-oldest or spill (default block)",
+		},
+		cli.StringFlag{
+			Name:  "spill-dir",
+			Usage: "Directory holding events spilled to disk by the spill backpressure policy",
+		},
+		cli.DurationFlag{
+			Name:  "poll-interval",
+			Usage: "How often to poll the bandwidth, repo, peer and bitswap stats of the node (default never)",
+		},
+		cli.BoolFlag{
+			Name:  "stop",
+			Usage: "Stop discovery, the nodes already added keep being collected",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		cmd, err := NewDiscoverCommand(c)
+		if err != nil {
+			return err
+		}
+		resp, err := SendCommand(cmd)
+		if err != nil {
+			exitSendError(err)
 			os.Exit(1)
 		}
 		io.Copy(os.Stdout, resp.Body)
@@ -97,7 +321,7 @@ var rmCmd = cli.Command{
 		}
 		resp, err := SendCommand(cmd)
 		if err != nil {
-			errlog.Fatal("Please run `ipfs-metrics start` first")
+			exitSendError(err)
 			os.Exit(1)
 		}
 		io.Copy(os.Stdout, resp.Body)
@@ -114,7 +338,588 @@ var listCmd = cli.Command{
 		}
 		resp, err := SendCommand(cmd)
 		if err != nil {
-			errlog.Fatal("Please run `ipfs-metrics start` first")
+			exitSendError(err)
+			os.Exit(1)
+		}
+		io.Copy(os.Stdout, resp.Body)
+		return nil
+	},
+}
+
+var statsCmd = cli.Command{
+	Name:  "stats",
+	Usage: "show throughput and health of ipfs daemons in metrics collection",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "Print the stats as json instead of a table",
+		},
+		cli.BoolFlag{
+			Name:  "watch, w",
+			Usage: "Keep refreshing the stats",
+		},
+		cli.DurationFlag{
+			Name:  "interval",
+			Value: 2 * time.Second,
+			Usage: "How often to refresh when watching",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		cmd := &Command{
+			Type: "stats",
+			Node: c.Args().First(),
+		}
+		for {
+			resp, err := SendCommand(cmd)
+			if err != nil {
+				exitSendError(err)
+				os.Exit(1)
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return err
+			}
+			var results []StatsResult
+			if err := json.Unmarshal(body, &results); err != nil {
+				//not a list of stats, so the daemon returned an error
+				os.Stdout.Write(body)
+				return nil
+			}
+			if c.Bool("watch") {
+				//clear the terminal before redrawing
+				fmt.Print("\033[H\033[2J")
+			}
+			if c.Bool("json") {
+				os.Stdout.Write(body)
+				fmt.Println()
+			} else {
+				printStats(os.Stdout, results)
+			}
+			if !c.Bool("watch") {
+				return nil
+			}
+			time.Sleep(c.Duration("interval"))
+		}
+	},
+}
+
+var levelsCmd = cli.Command{
+	Name:      "levels",
+	Usage:     "show or set the log levels of ipfs daemons in metrics collection",
+	ArgsUsage: "[subsystem=level...]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "node, n",
+			Usage: "Only the levels of this node",
+		},
+		cli.BoolFlag{
+			Name:  "subsystems, s",
+			Usage: "List every logging subsystem of the nodes, not only the ones with a level set",
+		},
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "Print the levels as json instead of a table",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		levels, err := ParseLogLevels(c.Args())
+		if err != nil {
+			return err
+		}
+		cmd := &Command{
+			Type:       "levels",
+			Node:       c.String("node"),
+			LogLevels:  levels,
+			Subsystems: c.Bool("subsystems"),
+		}
+		resp, err := SendCommand(cmd)
+		if err != nil {
+			exitSendError(err)
+			os.Exit(1)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		var results []LevelsResult
+		if err := json.Unmarshal(body, &results); err != nil {
+			//not a list of levels, so the daemon returned an error
+			os.Stdout.Write(body)
+			return nil
+		}
+		if c.Bool("json") {
+			os.Stdout.Write(body)
+			fmt.Println()
+		} else {
+			printLevels(os.Stdout, results)
+		}
+		return nil
+	},
+}
+
+var tailCmd = cli.Command{
+	Name:  "tail",
+	Usage: "stream events from ipfs daemons in metrics collection",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "node, n",
+			Usage: "Only show events of this node",
+		},
+		cli.StringFlag{
+			Name:  "system, s",
+			Usage: "Only show events of this system",
+		},
+		cli.StringFlag{
+			Name:  "event, e",
+			Usage: "Only show events with this name",
+		},
+		cli.StringSliceFlag{
+			Name:  "tag, t",
+			Usage: "Only show events with this tag=value",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Value: "pretty",
+			Usage: "Output format: pretty, json or lineprotocol",
+		},
+		cli.BoolFlag{
+			Name:  "color",
+			Usage: "Always colorize pretty output",
+		},
+		cli.BoolFlag{
+			Name:  "no-color",
+			Usage: "Never colorize pretty output",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		tags, err := MakeTags(c.StringSlice("tag"))
+		if err != nil {
+			return err
+		}
+		filter := TailFilter{
+			Node:   c.String("node"),
+			System: c.String("system"),
+			Event:  c.String("event"),
+			Tags:   tags,
+		}
+		format := c.String("format")
+		pretty := format == "pretty"
+		if pretty {
+			format = "json"
+		}
+		stream, err := OpenTail(filter, format)
+		if err != nil {
+			return err
+		}
+		defer stream.Close()
+		color := (isTerminal(os.Stdout) || c.Bool("color")) && !c.Bool("no-color")
+		return printTail(os.Stdout, stream, pretty, color)
+	},
+}
+
+var topCmd = cli.Command{
+	Name:  "top",
+	Usage: "interactive dashboard of event rates and durations per node and system",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "node, n",
+			Usage: "Only show events of this node",
+		},
+		cli.StringFlag{
+			Name:  "system, s",
+			Usage: "Only show events of this system",
+		},
+		cli.StringSliceFlag{
+			Name:  "tag, t",
+			Usage: "Only show events with this tag=value",
+		},
+		cli.DurationFlag{
+			Name:  "refresh",
+			Value: time.Second,
+			Usage: "How often the screen is redrawn",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		tags, err := MakeTags(c.StringSlice("tag"))
+		if err != nil {
+			return err
+		}
+		filter := TailFilter{
+			Node:   c.String("node"),
+			System: c.String("system"),
+			Tags:   tags,
+		}
+		return RunTop(filter, c.Duration("refresh"))
+	},
+}
+
+var replayCmd = cli.Command{
+	Name:      "replay",
+	Usage:     "replay a recorded archive or a jsonl file of events into an output",
+	ArgsUsage: "<file> [tagKey1=tagValue1...tagKeyn=tagValuen]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "output, o",
+			Usage: "Output to which the events will flow (if empty will use stdout)",
+		},
+		cli.BoolFlag{
+			Name:  "lineprotocol, lp",
+			Usage: "Use Line Protocol Format (Influxdb) when writing to output instead of json",
+		},
+		cli.BoolFlag{
+			Name:  "flatten",
+			Usage: "Write the nested objects and arrays of the events as line protocol fields",
+		},
+		cli.StringFlag{
+			Name:  "separator",
+			Usage: "Joins the keys of nested objects into field names when flattening (default .)",
+		},
+		cli.StringFlag{
+			Name:  "arrays",
+			Usage: "Flatten arrays by joining their elements into one field, or explode them into a field per element: join or explode (default join)",
+		},
+		cli.StringFlag{
+			Name:  "array-separator",
+			Usage: "Joins the elements of arrays when flattening (default ,)",
+		},
+		cli.StringFlag{
+			Name:  "trace",
+			Usage: "Write spans in the Chrome trace format to this file, on the disk of ipfs-metricsd, instead of an output",
+		},
+		cli.StringSliceFlag{
+			Name:  "filter, f",
+			Usage: "Only keep events matching field=value, or drop events matching field!=value",
+		},
+		cli.IntFlag{
+			Name:  "batch-size",
+			Usage: "Number of events written to the output at once",
+		},
+		cli.IntFlag{
+			Name:  "buffer-size",
+			Usage: "Number of events buffered between each stage of collection (default 64)",
+		},
+		cli.StringFlag{
+			Name:  "backpressure",
+			Usage: "What to do when the output can not keep up: block, drop-newest, drop-oldest or spill (default block)",
+		},
+		cli.StringFlag{
+			Name:  "spill-dir",
+			Usage: "Directory holding events spilled to disk by the spill backpressure policy",
+		},
+		cli.StringFlag{
+			Name:  "log-format",
+			Usage: "Format of the raw events of the file: auto, eventlog for go-log v1 or zap for go-log v2 (default auto)",
+		},
+		cli.StringFlag{
+			Name:  "speed",
+			Usage: "Multiplier of the original pace, e.g. 10x, or max to replay as fast as possible (default 1)",
+		},
+		cli.BoolFlag{
+			Name:  "rewrite-time",
+			Usage: "Shift the times of the events so the replay starts now",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		cmd, err := NewReplayCommand(c)
+		if err != nil {
+			fmt.Fprint(os.Stdout, "ipfs-metrics replay [file] -o [ip:port] --speed [multiplier|max] [tagKey1=tagValue1...tagKeyn=tagValuen]\n\n")
+			return err
+		}
+		resp, err := SendCommand(cmd)
+		if err != nil {
+			exitSendError(err)
+			os.Exit(1)
+		}
+		io.Copy(os.Stdout, resp.Body)
+		return nil
+	},
+}
+
+var correlateCmd = cli.Command{
+	Name:  "correlate",
+	Usage: "join the events of every ipfs daemon in collection into operations spanning nodes",
+	Flags: []cli.Flag{
+		cli.DurationFlag{
+			Name:  "window",
+			Value: defaultCorrelationWindow,
+			Usage: "How long an operation may go without events before it is written",
+		},
+		cli.StringSliceFlag{
+			Name:  "key",
+			Usage: "Event field joining events, may be repeated (default requestId, session and peerID)",
+		},
+		cli.IntFlag{
+			Name:  "min-nodes",
+			Value: defaultCorrelationMinNodes,
+			Usage: "Nodes an operation must span to be written",
+		},
+		cli.StringFlag{
+			Name:  "output, o",
+			Usage: "Output to which the operations will flow (if empty will use stdout)",
+		},
+		cli.BoolFlag{
+			Name:  "lineprotocol, lp",
+			Usage: "Use Line Protocol Format (Influxdb) when writing to output instead of json",
+		},
+		cli.StringFlag{
+			Name:  "trace",
+			Usage: "Write the operations in the Chrome trace format to this file, on the disk of ipfs-metricsd, instead of an output",
+		},
+		cli.BoolFlag{
+			Name:  "stop",
+			Usage: "Stop correlating, the operations still open are written",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		cmd, err := NewCorrelateCommand(c)
+		if err != nil {
+			return err
+		}
+		resp, err := SendCommand(cmd)
+		if err != nil {
+			exitSendError(err)
+			os.Exit(1)
+		}
+		io.Copy(os.Stdout, resp.Body)
+		return nil
+	},
+}
+
+var analyzeCmd = cli.Command{
+	Name:      "analyze",
+	Usage:     "report on the events of recorded archives or jsonl event logs, without a daemon",
+	ArgsUsage: "<file>...",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "Print the report as json instead of tables",
+		},
+		cli.IntFlag{
+			Name:  "top",
+			Value: analyzeTopSlow,
+			Usage: "Number of slowest operations to list",
+		},
+		cli.StringSliceFlag{
+			Name:  "filter, f",
+			Usage: "Only analyze events matching field=value, or skip events matching field!=value",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if len(c.Args()) == 0 {
+			return errors.New("File to analyze required")
+		}
+		if c.Int("top") < 0 {
+			return errors.New("Top must not be negative")
+		}
+		filters, err := MakeFilters(c.StringSlice("filter"))
+		if err != nil {
+			return err
+		}
+		report, err := Analyze(c.Args(), filters, c.Int("top"))
+		if err != nil {
+			return err
+		}
+		if c.Bool("json") {
+			b, err := json.MarshalIndent(report, "", "\t")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(b))
+			return nil
+		}
+		printAnalyzeReport(os.Stdout, report)
+		return nil
+	},
+}
+
+var traceCmd = cli.Command{
+	Name:      "trace",
+	Usage:     "convert recorded archives or jsonl event logs into a Chrome trace for chrome://tracing or Perfetto",
+	ArgsUsage: "<file>...",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "output, o",
+			Usage: "Trace file to write (if empty will use stdout)",
+		},
+		cli.StringSliceFlag{
+			Name:  "filter, f",
+			Usage: "Only trace events matching field=value, or skip events matching field!=value",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if len(c.Args()) == 0 {
+			return errors.New("File to trace required")
+		}
+		filters, err := MakeFilters(c.StringSlice("filter"))
+		if err != nil {
+			return err
+		}
+		return traceFiles(c.Args(), c.String("output"), filters)
+	},
+}
+
+var recordCmd = cli.Command{
+	Name:      "record",
+	Usage:     "record the raw events of an ipfs daemon in collection to an archive",
+	ArgsUsage: "<node>",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "file",
+			Usage: "Archive to write, on the disk of ipfs-metricsd (default <node>-<time>.ipfslog.gz in its working directory)",
+		},
+		cli.BoolFlag{
+			Name:  "stop",
+			Usage: "Stop recording and close the archive",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		node := c.Args().First()
+		if len(node) == 0 {
+			return errors.New("Node to record required")
+		}
+		cmd := &Command{
+			Type:   "record",
+			Node:   node,
+			Record: c.String("file"),
+		}
+		if c.Bool("stop") {
+			cmd.Type = "stop-record"
+		}
+		resp, err := SendCommand(cmd)
+		if err != nil {
+			exitSendError(err)
+			os.Exit(1)
+		}
+		io.Copy(os.Stdout, resp.Body)
+		return nil
+	},
+}
+
+var pauseCmd = cli.Command{
+	Name:  "pause",
+	Usage: "stop reading events from an ipfs daemon, keeping it in metrics collection",
+	Action: func(c *cli.Context) error {
+		cmd := &Command{
+			Type: "pause",
+			Node: c.Args().First(),
+		}
+		resp, err := SendCommand(cmd)
+		if err != nil {
+			exitSendError(err)
+			os.Exit(1)
+		}
+		io.Copy(os.Stdout, resp.Body)
+		return nil
+	},
+}
+
+var resumeCmd = cli.Command{
+	Name:  "resume",
+	Usage: "resume reading events from a paused ipfs daemon",
+	Action: func(c *cli.Context) error {
+		cmd := &Command{
+			Type: "resume",
+			Node: c.Args().First(),
+		}
+		resp, err := SendCommand(cmd)
+		if err != nil {
+			exitSendError(err)
+			os.Exit(1)
+		}
+		io.Copy(os.Stdout, resp.Body)
+		return nil
+	},
+}
+
+var configCmd = cli.Command{
+	Name:  "config",
+	Usage: "work with config files",
+	Subcommands: []cli.Command{
+		{
+			Name:      "validate",
+			Usage:     "check config files and print every problem found",
+			ArgsUsage: "<config> [config...]",
+			Action: func(c *cli.Context) error {
+				if len(c.Args()) == 0 {
+					return errors.New("Config file to validate required")
+				}
+				invalid := 0
+				for _, path := range c.Args() {
+					if _, err := ValidateConfigFile(path); err != nil {
+						invalid++
+						fmt.Printf("%s: %v\n", path, err)
+						continue
+					}
+					fmt.Printf("%s: ok\n", path)
+				}
+				if invalid != 0 {
+					return errors.New(fmt.Sprintf("%d of %d configs invalid", invalid, len(c.Args())))
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "schema",
+			Usage: "print the JSON Schema of config files",
+			Action: func(c *cli.Context) error {
+				b, err := json.MarshalIndent(ConfigSchema(), "", "\t")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(b))
+				return nil
+			},
+		},
+	},
+}
+
+var updateCmd = cli.Command{
+	Name:  "update",
+	Usage: "change the tags, filters or output of an ipfs daemon in metrics collection",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "output, o",
+			Usage: "New output to which the event logs will flow",
+		},
+		cli.BoolFlag{
+			Name:  "stdout",
+			Usage: "Write the event logs to stdout of ipfs-metricsd",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "Format used when writing to the output: json or lineprotocol",
+		},
+		cli.IntFlag{
+			Name:  "batch-size",
+			Usage: "Number of events written to the output at once",
+		},
+		cli.StringSliceFlag{
+			Name:  "filter, f",
+			Usage: "Replace filters, only keep events matching field=value, or drop events matching field!=value",
+		},
+		cli.BoolFlag{
+			Name:  "clear-filters",
+			Usage: "Remove all filters",
+		},
+		cli.BoolFlag{
+			Name:  "clear-tags",
+			Usage: "Remove all tags other than nodeId",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		showUsage := func(w io.Writer) {
+			fmt.Fprint(w, "ipfs-metrics update -o [ip:port] --format [json|lineprotocol] -f [field=value] [node] [tagKey1=tagValue1...tagKeyn=tagValuen]\n\n")
+		}
+		cmd, err := NewUpdateCommand(c)
+		if err != nil {
+			showUsage(os.Stdout)
+			return err
+		}
+		resp, err := SendCommand(cmd)
+		if err != nil {
+			exitSendError(err)
 			os.Exit(1)
 		}
 		io.Copy(os.Stdout, resp.Body)
@@ -125,12 +930,105 @@ var listCmd = cli.Command{
 var startCmd = cli.Command{
 	Name:  "start",
 	Usage: "starts ipfs-metricsd",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "listen, l",
+			Value: defaultAPIAddress,
+			Usage: "Address the control api listens on: host:port, unix:path or a multiaddr",
+		},
+		cli.StringFlag{
+			Name:   "token",
+			Usage:  "Bearer token required by the control api",
+			EnvVar: "IPFS_METRICS_TOKEN",
+		},
+		cli.StringFlag{
+			Name:  "tls-cert",
+			Usage: "Certificate used to serve the control api over tls",
+		},
+		cli.StringFlag{
+			Name:  "tls-key",
+			Usage: "Key of the tls certificate",
+		},
+		cli.StringFlag{
+			Name:  "tls-client-ca",
+			Usage: "Require clients to present a certificate signed by this CA",
+		},
+		cli.DurationFlag{
+			Name:  "self-metrics-interval",
+			Value: 10 * time.Second,
+			Usage: "How often ipfs-metricsd writes its own metrics to the outputs in collection, 0 to disable",
+		},
+		cli.DurationFlag{
+			Name:  "shutdown-timeout",
+			Value: 30 * time.Second,
+			Usage: "How long to wait for events to be written on shutdown before abandoning them",
+		},
+		cli.BoolFlag{
+			Name:  "no-ui",
+			Usage: "Do not serve the web ui on /ui/ of the control api",
+		},
+	},
 	Action: func(c *cli.Context) error {
 		infolog.Println("ipfs-metricsd starting...")
-		http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		tlsConfig, err := serverTLSConfig(c.String("tls-cert"), c.String("tls-key"), c.String("tls-client-ca"))
+		if err != nil {
+			return err
+		}
+		listen := c.String("listen")
+		listener, err := listenAPI(listen)
+		if err != nil {
+			return err
+		}
+		token := c.String("token")
+		if len(token) == 0 && !isLocalAddress(listen) {
+			errlog.Printf("Control api on %s has no token, anyone who can reach it can change collection", listen)
+		}
+		if interval := c.Duration("self-metrics-interval"); interval > 0 {
+			go emitSelfMetrics(interval)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/debug/vars", expvar.Handler())
+		mux.HandleFunc("/tail", handleTail)
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 			handleConnection(w, r)
 		})
-		http.ListenAndServe(port, nil)
+		handler := requireToken(token, mux)
+		if !c.Bool("no-ui") {
+			root := http.NewServeMux()
+			root.Handle("/ui/", uiHandler())
+			root.Handle("/", handler)
+			handler = root
+		}
+		server := &http.Server{
+			Handler:   handler,
+			TLSConfig: tlsConfig,
+		}
+		//tail streams never finish on their own
+		server.RegisterOnShutdown(tailEvents.closeAll)
+
+		sig := make(chan os.Signal, 2)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			infolog.Printf("Control api listening on: %s\n", listen)
+			var err error
+			if tlsConfig != nil {
+				err = server.ServeTLS(listener, "", "")
+			} else {
+				err = server.Serve(listener)
+			}
+			if err != http.ErrServerClosed {
+				errlog.Fatal(err)
+			}
+		}()
+
+		<-sig
+		infolog.Println("ipfs-metricsd shutting down, interrupt again to exit immediately...")
+		go func() {
+			<-sig
+			errlog.Println("ipfs-metricsd interrupted, abandoning remaining events")
+			os.Exit(1)
+		}()
+		shutdownDaemon(server, c.Duration("shutdown-timeout"))
 		return nil
 	},
 }
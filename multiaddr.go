@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+//Converts the multiaddrs used by ipfs into a network and address which can be dialed:
+///ip4/127.0.0.1/tcp/5001, /ip6/::1/tcp/5001, /dns4/example.com/tcp/5001 and /unix/path/api.sock
+func ParseMultiaddr(ma string) (network, address string, err error) {
+	invalid := errors.New(fmt.Sprintf("Invalid multiaddr: %s", ma))
+	parts := strings.Split(strings.TrimPrefix(ma, "/"), "/")
+	if !strings.HasPrefix(ma, "/") || len(parts) < 2 {
+		return "", "", invalid
+	}
+	switch parts[0] {
+	case "unix":
+		path := "/" + strings.Join(parts[1:], "/")
+		if path == "/" {
+			return "", "", invalid
+		}
+		return "unix", path, nil
+	case "ip4", "ip6", "dns", "dns4", "dns6":
+		//the protocol after the port, e.g. /http, does not change what is dialed
+		if len(parts) < 4 || parts[2] != "tcp" || len(parts[1]) == 0 || len(parts[3]) == 0 {
+			return "", "", invalid
+		}
+		if parts[0] == "ip4" || parts[0] == "ip6" {
+			ip := net.ParseIP(parts[1])
+			if ip == nil || (parts[0] == "ip4") != (ip.To4() != nil) {
+				return "", "", invalid
+			}
+		}
+		if len(parts) > 4 && parts[4] != "http" {
+			return "", "", invalid
+		}
+		return "tcp", net.JoinHostPort(parts[1], parts[3]), nil
+	}
+	return "", "", invalid
+}
+
+func isMultiaddr(addr string) bool {
+	return strings.HasPrefix(addr, "/")
+}
+
+//Returns the network and address to dial the api of a source
+func (s Source) endpoint() (network, address string, err error) {
+	if isMultiaddr(s.Address) {
+		return ParseMultiaddr(s.Address)
+	}
+	return "tcp", net.JoinHostPort(s.Address, s.Port), nil
+}
+
+var dialClients sync.Map
+
+//Returns an http client which always dials the given address, so unix
+//sockets can be used, clients are shared so connections are reused
+func dialClient(network, address string) *http.Client {
+	key := network + "|" + address
+	if client, ok := dialClients.Load(key); ok {
+		return client.(*http.Client)
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, address)
+		},
+	}
+	client, _ := dialClients.LoadOrStore(key, &http.Client{Transport: transport})
+	return client.(*http.Client)
+}
+
+//Parses the input of the add command, a multiaddr or host:port
+func ParseInput(input string) (address, port string, err error) {
+	if isMultiaddr(input) {
+		if _, _, err := ParseMultiaddr(input); err != nil {
+			return "", "", err
+		}
+		return input, "", nil
+	}
+	address, port, err = net.SplitHostPort(input)
+	if err != nil || len(address) == 0 || len(port) == 0 {
+		return "", "", errors.New("Input format invalid")
+	}
+	return address, port, nil
+}
+
+//Parses the output of the add and update commands, host:port with ipv6 hosts in brackets
+func ParseOutput(output string) (address, port string, err error) {
+	address, port, err = net.SplitHostPort(output)
+	if err != nil || len(address) == 0 || len(port) == 0 {
+		return "", "", errors.New("Output format invalid")
+	}
+	return address, port, nil
+}
+
+//Returns the path of the ipfs repo, from the flag, $IPFS_PATH or ~/.ipfs
+func ipfsRepoPath(repo string) string {
+	if len(repo) != 0 {
+		return repo
+	}
+	if env := os.Getenv("IPFS_PATH"); len(env) != 0 {
+		return env
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".ipfs")
+}
+
+//Reads the api file a running ipfs daemon writes into its repo
+func ReadRepoAPI(repo string) (string, error) {
+	path := filepath.Join(ipfsRepoPath(repo), "api")
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("No ipfs daemon api found in repo, is the daemon running? %v", err))
+	}
+	ma := strings.TrimSpace(string(b))
+	if _, _, err := ParseMultiaddr(ma); err != nil {
+		return "", err
+	}
+	return ma, nil
+}
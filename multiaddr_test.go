@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseMultiaddr(t *testing.T) {
+	valid := map[string][2]string{
+		"/ip4/127.0.0.1/tcp/5001":      {"tcp", "127.0.0.1:5001"},
+		"/ip6/::1/tcp/5001":            {"tcp", "[::1]:5001"},
+		"/dns4/example.com/tcp/5001":   {"tcp", "example.com:5001"},
+		"/ip4/127.0.0.1/tcp/5001/http": {"tcp", "127.0.0.1:5001"},
+		"/unix/var/run/ipfs/api.sock":  {"unix", "/var/run/ipfs/api.sock"},
+	}
+	for ma, expected := range valid {
+		network, address, err := ParseMultiaddr(ma)
+		if err != nil {
+			t.Error(fmt.Sprintf("Multiaddr is valid: %s %v", ma, err))
+			continue
+		}
+		if network != expected[0] || address != expected[1] {
+			t.Error(fmt.Sprintf("Invalid Multiaddr: %s %s Expected: %s %s", network, address, expected[0], expected[1]))
+		}
+	}
+}
+
+func TestInValidMultiaddr(t *testing.T) {
+	invalid := [...]string{"127.0.0.1:5001", "/ip4/127.0.0.1", "/ip4/::1/tcp/5001", "/ip6/127.0.0.1/tcp/5001", "/ip4/127.0.0.1/udp/5001", "/unix", "/ip4/127.0.0.1/tcp/5001/ws", "/onion/abc"}
+	for _, ma := range invalid {
+		if _, _, err := ParseMultiaddr(ma); err == nil {
+			t.Error(fmt.Sprintf("Multiaddr is invalid: %s", ma))
+		}
+	}
+}
+
+func TestParseInput(t *testing.T) {
+	inputs := map[string][2]string{
+		"127.0.0.1:5001":          {"127.0.0.1", "5001"},
+		"[::1]:5001":              {"::1", "5001"},
+		"/ip6/::1/tcp/5001":       {"/ip6/::1/tcp/5001", ""},
+		"/ip4/127.0.0.1/tcp/5001": {"/ip4/127.0.0.1/tcp/5001", ""},
+	}
+	for input, expected := range inputs {
+		address, port, err := ParseInput(input)
+		if err != nil || address != expected[0] || port != expected[1] {
+			t.Error(fmt.Sprintf("Invalid Input: %s %s %v Expected: %s %s", address, port, err, expected[0], expected[1]))
+		}
+		source := Source{Address: address, Port: port}
+		if _, _, err := source.endpoint(); err != nil {
+			t.Error(fmt.Sprintf("Invalid Endpoint: %v %v", source, err))
+		}
+	}
+	if _, _, err := ParseInput("::1:5001:1"); err == nil {
+		t.Error("Input is invalid: ::1:5001:1")
+	}
+}
+
+func TestParseOutput(t *testing.T) {
+	outputs := map[string][2]string{
+		"127.0.0.1:8086":  {"127.0.0.1", "8086"},
+		"[::1]:8086":      {"::1", "8086"},
+		"influx.lan:8086": {"influx.lan", "8086"},
+	}
+	for output, expected := range outputs {
+		address, port, err := ParseOutput(output)
+		if err != nil || address != expected[0] || port != expected[1] {
+			t.Error(fmt.Sprintf("Invalid Output: %s %s %v Expected: %s %s", address, port, err, expected[0], expected[1]))
+		}
+		if sink := (Sink{Address: address, Port: port}); sink.String() != output {
+			t.Error(fmt.Sprintf("Invalid Sink: %s Expected: %s", sink, output))
+		}
+	}
+	for _, output := range []string{"::1:8086", "127.0.0.1", ":8086"} {
+		if _, _, err := ParseOutput(output); err == nil {
+			t.Error(fmt.Sprintf("Output is invalid: %s", output))
+		}
+	}
+}
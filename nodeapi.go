@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+//The http api of an ipfs node. go-ipfs 0.5 and later only accept POST, older
+//versions accept it too, so every request to a node is sent with POST.
+
+const ipfsLogTailPath = "/api/v0/log/tail?encoding=json&stream-channels=true"
+
+//Longest a request to the api of a node may take, the log stream has no limit
+const apiRequestTimeout = 3 * time.Second
+
+//Returns the url of a path on the api of the source, and the client to request it with
+func (s Source) apiRequest(path string) (string, *http.Client, error) {
+	network, address, err := s.endpoint()
+	if err != nil {
+		return "", nil, err
+	}
+	host := address
+	if network == "unix" {
+		//only used for the Host header, the socket is dialed directly
+		host = "ipfs"
+	}
+	return fmt.Sprintf("http://%s%s", host, path), dialClient(network, address), nil
+}
+
+//Request a path on the api of a node, a response with an error status is returned as an error
+func postIpfsAPI(ctx context.Context, source Source, path string) (*http.Response, error) {
+	url, client, err := source.apiRequest(path)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.New(fmt.Sprintf("%s%s: %s", source, path, resp.Status))
+	}
+	return resp, nil
+}
+
+//Decode the json response of an api path, giving up after the request timeout
+func postAPIJSON(ctx context.Context, source Source, path string, v interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, apiRequestTimeout)
+	defer cancel()
+	resp, err := postIpfsAPI(ctx, source, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+//Returns the node whose events the source collects
+func sourceNodeId(source Source) (string, error) {
+	if source.isReplay() {
+		return replayNodeId(source.File)
+	}
+	return GetNodeId(context.Background(), source)
+}
+
+//Returns the id of the node, failing fast for apis without one
+func GetNodeId(ctx context.Context, source Source) (string, error) {
+	var id struct {
+		ID string
+	}
+	if err := postAPIJSON(ctx, source, "/api/v0/id", &id); err != nil {
+		return "", err
+	}
+	if len(id.ID) == 0 {
+		return "", errors.New("Could not get nodeId, are you sure this is an ipfs daemon?")
+	}
+	return id.ID, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+//An ipfs api which like go-ipfs 0.5 and later only accepts POST
+func testNodeAPI(t *testing.T) (Source, func()) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "405 - Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v0/id":
+			fmt.Fprint(w, `{"ID":"QmNode"}`)
+		case "/api/v0/log/tail":
+			fmt.Fprint(w, `{"system":"dht","event":"findPeer","time":"2017-11-17T22:09:10Z"}`+"\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Source{Address: host, Port: port}, server.Close
+}
+
+func TestNodeAPIPost(t *testing.T) {
+	source, stop := testNodeAPI(t)
+	defer stop()
+	if id, err := GetNodeId(context.Background(), source); err != nil || id != "QmNode" {
+		t.Error(fmt.Sprintf("Invalid Node Id: %s %v", id, err))
+	}
+	resp, err := postIpfsAPI(context.Background(), source, ipfsLogTailPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(b), `"event":"findPeer"`) {
+		t.Error(fmt.Sprintf("Invalid Log Tail: %s", b))
+	}
+	//an error status is not read as the response
+	if _, err := postIpfsAPI(context.Background(), source, "/api/v0/unknown"); err == nil {
+		t.Error("Read a 404 response")
+	}
+}
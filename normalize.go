@@ -0,0 +1,103 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+//Formats of the event log of a node
+const (
+	LogFormatAuto     = "auto"     //detected from the keys of each event, the default
+	LogFormatEventLog = "eventlog" //the event log of go-log v1: system, event, time
+	LogFormatZap      = "zap"      //the json of go-log v2, written by zap: logger, msg, ts
+)
+
+//Measurement of zap events without a logger
+const defaultZapSystem = "ipfs"
+
+//Times zap writes, go-log v2 uses ISO8601 with milliseconds
+var zapTimeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05.000Z0700"}
+
+func validLogFormat(format string) bool {
+	return format == "" || format == LogFormatAuto || format == LogFormatEventLog || format == LogFormatZap
+}
+
+//Returns the format of a message by its keys, zap if it has the keys
+//only zap writes and not the ones of the event log
+func detectLogFormat(message map[string]interface{}) string {
+	if _, ok := message["system"]; ok {
+		return LogFormatEventLog
+	}
+	_, msg := message["msg"]
+	_, logger := message["logger"]
+	_, ts := message["ts"]
+	if ts && (msg || logger) {
+		return LogFormatZap
+	}
+	return LogFormatEventLog
+}
+
+//Map the message of an event in the format of the node into the event log
+//the rest of the daemon expects, so ipfs versions write the same measurements and tags
+func (le *LogEvent) normalize(format string) {
+	if le.Message == nil {
+		return
+	}
+	if len(format) == 0 || format == LogFormatAuto {
+		format = detectLogFormat(le.Message)
+	}
+	switch format {
+	case LogFormatZap:
+		normalizeZap(le.Message)
+	default:
+		normalizeEventLog(le.Message)
+	}
+	le.parse()
+}
+
+//The event log is the common format, only durations written as strings are turned into nanoseconds
+func normalizeEventLog(message map[string]interface{}) {
+	normalizeDuration(message)
+}
+
+//The logger is the system, the level, caller and message are kept.
+//The message is free text, so it is not the event, which is a tag:
+//only an event key logged as a field, e.g. log.Infow(msg, "event", name), is the event
+func normalizeZap(message map[string]interface{}) {
+	system := defaultZapSystem
+	if logger, ok := tagString(message["logger"]); ok && len(logger) != 0 {
+		system = logger
+	}
+	message["system"] = system
+	delete(message, "logger")
+	if t, ok := zapTime(message["ts"]); ok {
+		message["time"] = t.UTC().Format(time.RFC3339Nano)
+		delete(message, "ts")
+	}
+	normalizeDuration(message)
+}
+
+//zap writes times as ISO8601 strings or as seconds since the epoch
+func zapTime(ts interface{}) (time.Time, bool) {
+	switch ts := ts.(type) {
+	case string:
+		for _, layout := range zapTimeLayouts {
+			if t, err := time.Parse(layout, ts); err == nil {
+				return t, true
+			}
+		}
+	case float64:
+		//scaled apart, nanoseconds since the epoch are more digits than a float64 holds
+		sec, frac := math.Modf(ts)
+		return time.Unix(int64(sec), int64(frac*float64(time.Second))), true
+	}
+	return time.Time{}, false
+}
+
+func normalizeDuration(message map[string]interface{}) {
+	if s, ok := message["duration"].(string); ok {
+		if d, err := time.ParseDuration(s); err == nil {
+			message["duration"] = float64(d)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNormalizeZap(t *testing.T) {
+	le, err := DecodeLogEvent([]byte(`{"level":"info","ts":"2017-11-17T22:09:10.080Z","logger":"dht","caller":"dht/query.go:123","msg":"finding providers for QmA","event":"findProviders","requestId":4,"duration":"1.5s"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	le.normalize(LogFormatAuto)
+	if le.System != "dht" || le.Event != "findProviders" || le.Time.UnixNano() != 1510956550080000000 || le.Duration.Seconds() != 1.5 {
+		t.Fatal(fmt.Sprintf("Invalid Event: %+v", le))
+	}
+	if _, ok := le.Message["ts"]; ok || le.Message["caller"] != "dht/query.go:123" || le.Message["msg"] != "finding providers for QmA" {
+		t.Error(fmt.Sprintf("Invalid Message: %v", le.Message))
+	}
+	b, err := le.ToLP()
+	if err != nil || string(b) != "dht,event=findProviders,requestId=4,level=info duration=1500000000.000000 1510956550080000000\n" {
+		t.Error(fmt.Sprintf("Invalid Line Protocol: %s %v", b, err))
+	}
+
+	//zap writes seconds since the epoch unless told otherwise
+	le, _ = DecodeLogEvent([]byte(`{"level":"debug","ts":1510956550.5,"msg":"started"}`))
+	le.normalize(LogFormatZap)
+	if le.System != defaultZapSystem || le.Event != "" || le.Message["msg"] != "started" || le.Time.UnixNano() != 1510956550500000000 {
+		t.Error(fmt.Sprintf("Invalid Event: %+v", le))
+	}
+	//microseconds are kept
+	if ts, _ := zapTime(1510956550.123456); ts.Unix() != 1510956550 || ts.Nanosecond()/1000 != 123456 {
+		t.Error(fmt.Sprintf("Invalid Time: %v", ts.UnixNano()))
+	}
+	//the message is not a tag, every message would be a series
+	if b, err := le.ToLP(); err != nil || string(b) != "ipfs,level=debug duration=0 1510956550500000000\n" {
+		t.Error(fmt.Sprintf("Invalid Line Protocol: %s %v", b, err))
+	}
+}
+
+func TestNormalizeEventLog(t *testing.T) {
+	raw := `{"system":"swarm2","event":"swarmDialAttemptSync","time":"2017-11-17T22:09:10Z","duration":1129297969,"msg":"kept"}`
+	le, _ := DecodeLogEvent([]byte(raw))
+	le.normalize(LogFormatAuto)
+	if le.System != "swarm2" || le.Event != "swarmDialAttemptSync" || le.Duration != 1129297969 || le.Message["msg"] != "kept" {
+		t.Error(fmt.Sprintf("Invalid Event: %+v", le))
+	}
+	//an event log is left as it is even when it looks like zap
+	le, _ = DecodeLogEvent([]byte(`{"ts":"2017-11-17T22:09:10Z","msg":"hello"}`))
+	le.normalize(LogFormatEventLog)
+	if le.System != "" || le.Message["msg"] != "hello" {
+		t.Error(fmt.Sprintf("Invalid Event: %+v", le))
+	}
+}
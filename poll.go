@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	cli "github.com/codegangsta/cli"
+)
+
+//measurement of the events made from polled stats
+const statsMeasurement = "ipfs_stats"
+
+//A stats endpoint of the ipfs api, and how its response becomes event fields
+type statsPoll struct {
+	event string
+	path  string
+	poll  func(ctx context.Context, source Source, path string) (map[string]interface{}, error)
+}
+
+var statsPolls = []statsPoll{
+	{"bw", "/api/v0/stats/bw", pollBandwidth},
+	{"repo", "/api/v0/repo/stat", pollRepo},
+	{"peers", "/api/v0/swarm/peers", pollPeers},
+	{"bitswap", "/api/v0/stats/bitswap", pollBitswap},
+}
+
+func pollBandwidth(ctx context.Context, source Source, path string) (map[string]interface{}, error) {
+	var bw struct {
+		TotalIn  int64
+		TotalOut int64
+		RateIn   float64
+		RateOut  float64
+	}
+	if err := postAPIJSON(ctx, source, path, &bw); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"total_in":  bw.TotalIn,
+		"total_out": bw.TotalOut,
+		"rate_in":   bw.RateIn,
+		"rate_out":  bw.RateOut,
+	}, nil
+}
+
+func pollRepo(ctx context.Context, source Source, path string) (map[string]interface{}, error) {
+	var repo struct {
+		RepoSize   int64
+		StorageMax int64
+		NumObjects int64
+	}
+	if err := postAPIJSON(ctx, source, path, &repo); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"repo_size":   repo.RepoSize,
+		"storage_max": repo.StorageMax,
+		"num_objects": repo.NumObjects,
+	}, nil
+}
+
+func pollPeers(ctx context.Context, source Source, path string) (map[string]interface{}, error) {
+	var peers struct {
+		Peers []struct{}
+	}
+	if err := postAPIJSON(ctx, source, path, &peers); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"peers": len(peers.Peers),
+	}, nil
+}
+
+func pollBitswap(ctx context.Context, source Source, path string) (map[string]interface{}, error) {
+	var bitswap struct {
+		ProvideBufLen   int
+		Wantlist        []interface{}
+		Peers           []string //peers with a ledger
+		BlocksReceived  int64
+		BlocksSent      int64
+		DataReceived    int64
+		DataSent        int64
+		DupBlksReceived int64
+		DupDataReceived int64
+	}
+	if err := postAPIJSON(ctx, source, path, &bitswap); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"wantlist":          len(bitswap.Wantlist),
+		"ledgers":           len(bitswap.Peers),
+		"provide_buf_len":   bitswap.ProvideBufLen,
+		"blocks_received":   bitswap.BlocksReceived,
+		"blocks_sent":       bitswap.BlocksSent,
+		"data_received":     bitswap.DataReceived,
+		"data_sent":         bitswap.DataSent,
+		"dup_blks_received": bitswap.DupBlksReceived,
+		"dup_data_received": bitswap.DupDataReceived,
+	}, nil
+}
+
+func (s Source) pollInterval() time.Duration {
+	interval, _ := time.ParseDuration(s.PollInterval)
+	return interval
+}
+
+func pollIntervalFlag(c *cli.Context) string {
+	if interval := c.Duration("poll-interval"); interval > 0 {
+		return interval.String()
+	}
+	return ""
+}
+
+//Poll the stats endpoints of the source -> Filter, alongside the event log
+func (lp *LogProxy) PollStats(interval time.Duration) {
+	defer lp.readers.Done()
+	infolog.Printf("Poller Open: %s every %s Name: %s\n", lp.source(), interval, lp.Name)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-lp.ctx.Done():
+			return
+		case <-lp.stopRead:
+			infolog.Printf("Poller Close: %s Name: %s\n", lp.source(), lp.Name)
+			return
+		case <-ticker.C:
+			if lp.State() == StatePaused {
+				continue
+			}
+			lp.pollStats()
+		}
+	}
+}
+
+//One event per endpoint, endpoints which fail are skipped until the next poll
+func (lp *LogProxy) pollStats() {
+	source := lp.source()
+	ts := time.Now().UTC().Format(time.RFC3339Nano)
+	for _, sp := range statsPolls {
+		fields, err := sp.poll(lp.ctx, source, sp.path)
+		if err != nil {
+			if lp.reading() {
+				errlog.Printf("Poll Source: %s %s error: %v", source, sp.path, err)
+			}
+			continue
+		}
+		event := NewLogEvent(map[string]interface{}{
+			"system": statsMeasurement,
+			"event":  sp.event,
+			"time":   ts,
+		})
+		event.ExtraFields = fields
+		lp.Stats.read()
+		lp.push(lp.Inbound, lp.inSpill, event)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPollStats(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "405 - Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v0/stats/bw":
+			fmt.Fprint(w, `{"TotalIn":100,"TotalOut":200,"RateIn":1.5,"RateOut":2.5}`)
+		case "/api/v0/repo/stat":
+			fmt.Fprint(w, `{"RepoSize":4096,"StorageMax":10000000000,"NumObjects":12}`)
+		case "/api/v0/stats/bitswap":
+			fmt.Fprint(w, `{"ProvideBufLen":1,"Wantlist":[{"/":"QmA"}],"Peers":["QmB","QmC"],"BlocksReceived":5,"DupBlksReceived":2}`)
+		default:
+			//a failing endpoint is skipped
+			http.Error(w, "500 - Internal Server Error", http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lp := &LogProxy{
+		Name:     "QmNode",
+		Source:   Source{Address: host, Port: port},
+		Inbound:  make(chan LogEvent, len(statsPolls)),
+		stopRead: make(chan struct{}),
+		ctx:      ctx,
+	}
+	lp.pollStats()
+	close(lp.Inbound)
+
+	events := make(map[string]LogEvent)
+	for le := range lp.Inbound {
+		if le.System != statsMeasurement {
+			t.Error(fmt.Sprintf("Invalid System: %s", le.System))
+		}
+		events[le.Event] = le
+	}
+	if _, ok := events["peers"]; ok || len(events) != 3 || lp.Stats.eventsRead != 3 {
+		t.Fatal(fmt.Sprintf("Invalid Polled Events: %v", events))
+	}
+	tests := []struct {
+		event string
+		field string
+		value interface{}
+	}{
+		{"bw", "total_in", int64(100)},
+		{"bw", "rate_out", 2.5},
+		{"repo", "repo_size", int64(4096)},
+		{"repo", "num_objects", int64(12)},
+		{"bitswap", "wantlist", 1},
+		{"bitswap", "ledgers", 2},
+		{"bitswap", "dup_blks_received", int64(2)},
+	}
+	for _, test := range tests {
+		if v := events[test.event].ExtraFields[test.field]; v != test.value {
+			t.Error(fmt.Sprintf("Invalid Field: %s %s: %v expected: %v", test.event, test.field, v, test.value))
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Kinds of sources
+const (
+	SourceEventLog   = "eventlog"   //tail the event log of the node, the default
+	SourcePrometheus = "prometheus" //scrape the prometheus metrics of the node
+	SourceReplay     = "replay"     //re-emit the events of a recorded file
+)
+
+const (
+	defaultMetricsPath    = "/debug/metrics/prometheus"
+	defaultScrapeInterval = 15 * time.Second
+)
+
+func (s Source) isPrometheus() bool {
+	return s.Type == SourcePrometheus
+}
+
+func validSourceType(t string) bool {
+	return t == "" || t == SourceEventLog || t == SourcePrometheus || t == SourceReplay
+}
+
+//Name of the proxy collecting the source of a node, prometheus sources are
+//named apart so the event log of the same node can be collected too,
+//replays are named after their file
+func (s Source) proxyName(nodeId string) string {
+	if s.isReplay() {
+		return SourceReplay + "-" + filepath.Base(s.File)
+	}
+	if s.isPrometheus() {
+		return nodeId + "-" + SourcePrometheus
+	}
+	return nodeId
+}
+
+func (s Source) scrapeInterval() time.Duration {
+	interval, err := time.ParseDuration(s.ScrapeInterval)
+	if err != nil || interval <= 0 {
+		return defaultScrapeInterval
+	}
+	return interval
+}
+
+func (s Source) metricsPath() string {
+	if len(s.MetricsPath) == 0 {
+		return defaultMetricsPath
+	}
+	return s.MetricsPath
+}
+
+//One sample of the prometheus text format
+type PromSample struct {
+	Name      string
+	Labels    []Tag
+	Value     float64
+	Timestamp int64 //milliseconds since the epoch, 0 if the sample has none
+}
+
+//Parse the prometheus text exposition format, comments and blank lines are skipped
+//https://prometheus.io/docs/instrumenting/exposition_formats/#text-based-format
+func ParsePrometheus(r io.Reader) ([]PromSample, error) {
+	var samples []PromSample
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sample, err := parsePromLine(line)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Prometheus line %d: %v", n, err))
+		}
+		samples = append(samples, sample)
+	}
+	return samples, scanner.Err()
+}
+
+//name{label="value",...} value [timestamp]
+func parsePromLine(line string) (PromSample, error) {
+	var sample PromSample
+	end := strings.IndexAny(line, "{ \t")
+	if end <= 0 {
+		return sample, errors.New(fmt.Sprintf("no value: %s", line))
+	}
+	sample.Name = line[:end]
+	rest := line[end:]
+	if rest[0] == '{' {
+		labels, n, err := parsePromLabels(rest)
+		if err != nil {
+			return sample, err
+		}
+		sample.Labels = labels
+		rest = rest[n:]
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return sample, errors.New(fmt.Sprintf("invalid value: %s", line))
+	}
+	value, err := parsePromValue(fields[0])
+	if err != nil {
+		return sample, err
+	}
+	sample.Value = value
+	if len(fields) == 2 {
+		ts, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return sample, errors.New(fmt.Sprintf("invalid timestamp: %s", fields[1]))
+		}
+		sample.Timestamp = ts
+	}
+	return sample, nil
+}
+
+//Parse {label="value",...}, returns the labels and the length of the text parsed
+func parsePromLabels(text string) ([]Tag, int, error) {
+	var labels []Tag
+	i := 1
+	for {
+		for i < len(text) && (text[i] == ' ' || text[i] == ',') {
+			i++
+		}
+		if i < len(text) && text[i] == '}' {
+			return labels, i + 1, nil
+		}
+		eq := strings.IndexByte(text[i:], '=')
+		if eq <= 0 || i+eq+1 >= len(text) || text[i+eq+1] != '"' {
+			return nil, 0, errors.New(fmt.Sprintf("invalid labels: %s", text))
+		}
+		name := strings.TrimSpace(text[i : i+eq])
+		i += eq + 2
+		var value strings.Builder
+		for {
+			if i >= len(text) {
+				return nil, 0, errors.New(fmt.Sprintf("unterminated label value: %s", text))
+			}
+			c := text[i]
+			i++
+			if c == '"' {
+				break
+			}
+			if c == '\\' && i < len(text) {
+				switch text[i] {
+				case 'n':
+					c = '\n'
+				default:
+					c = text[i]
+				}
+				i++
+			}
+			value.WriteByte(c)
+		}
+		labels = append(labels, MakeTag(name, value.String()))
+	}
+}
+
+func parsePromValue(s string) (float64, error) {
+	switch s {
+	case "+Inf", "Inf":
+		return math.Inf(1), nil
+	case "-Inf":
+		return math.Inf(-1), nil
+	case "NaN":
+		return math.NaN(), nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("invalid value: %s", s))
+	}
+	return v, nil
+}
+
+//The sample as an event, the metric name is the measurement,
+//the labels are tags and the value is a field
+func (ps PromSample) LogEvent(scraped time.Time) LogEvent {
+	ts := scraped
+	if ps.Timestamp != 0 {
+		ts = time.Unix(0, ps.Timestamp*int64(time.Millisecond))
+	}
+	le := NewLogEvent(map[string]interface{}{
+		"system": ps.Name,
+		"time":   ts.UTC().Format(time.RFC3339Nano),
+	})
+	le.Tags = append([]Tag(nil), ps.Labels...)
+	le.ExtraFields = map[string]interface{}{"value": ps.Value}
+	return le
+}
+
+//Scrape the prometheus endpoint of the source -> Filter
+func (lp *LogProxy) ScrapeMetrics(interval time.Duration) {
+	defer lp.readers.Done()
+	infolog.Printf("Scraper Open: %s every %s Name: %s\n", lp.source(), interval, lp.Name)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if lp.State() != StatePaused {
+			lp.scrape()
+		}
+		select {
+		case <-lp.ctx.Done():
+			return
+		case <-lp.stopRead:
+			infolog.Printf("Scraper Close: %s Name: %s\n", lp.source(), lp.Name)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (lp *LogProxy) scrape() {
+	source := lp.source()
+	samples, err := scrapePrometheus(lp.ctx, source)
+	if err != nil {
+		if lp.reading() {
+			errlog.Printf("Scrape Source: %s error: %v", source, err)
+		}
+		return
+	}
+	now := time.Now()
+	for _, sample := range samples {
+		//line protocol has no way to write these
+		if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+			continue
+		}
+		lp.Stats.read()
+		lp.push(lp.Inbound, lp.inSpill, sample.LogEvent(now))
+	}
+}
+
+func scrapePrometheus(ctx context.Context, source Source) ([]PromSample, error) {
+	ctx, cancel := context.WithTimeout(ctx, source.scrapeInterval())
+	defer cancel()
+	url, client, err := source.apiRequest(source.metricsPath())
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/plain;version=0.0.4")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("%s: %s", source.metricsPath(), resp.Status))
+	}
+	return ParsePrometheus(resp.Body)
+}
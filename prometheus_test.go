@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+const promText = `# HELP go_goroutines Number of goroutines that currently exist.
+# TYPE go_goroutines gauge
+go_goroutines 71
+
+# TYPE ipfs_info gauge
+ipfs_info{commit="",version="0.17.0"} 1
+# TYPE http_request_duration_seconds summary
+http_request_duration_seconds{handler="/api/v0/id",quantile="0.5"} 0.0012
+http_request_duration_seconds_sum{handler="/api/v0/id"} 1.5e-02
+http_request_duration_seconds_count{handler="/api/v0/id"} 12 1700000000000
+bucket_total{le="+Inf", path="a \"quoted\", spaced\\path"} 3
+flatfs_errors NaN
+`
+
+func TestParsePrometheus(t *testing.T) {
+	samples, err := ParsePrometheus(strings.NewReader(promText))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []PromSample{
+		{Name: "go_goroutines", Value: 71},
+		{Name: "ipfs_info", Labels: []Tag{{"commit", ""}, {"version", "0.17.0"}}, Value: 1},
+		{Name: "http_request_duration_seconds", Labels: []Tag{{"handler", "/api/v0/id"}, {"quantile", "0.5"}}, Value: 0.0012},
+		{Name: "http_request_duration_seconds_sum", Labels: []Tag{{"handler", "/api/v0/id"}}, Value: 0.015},
+		{Name: "http_request_duration_seconds_count", Labels: []Tag{{"handler", "/api/v0/id"}}, Value: 12, Timestamp: 1700000000000},
+		{Name: "bucket_total", Labels: []Tag{{"le", "+Inf"}, {"path", `a "quoted", spaced\path`}}, Value: 3},
+	}
+	if len(samples) != len(expected)+1 {
+		t.Fatal(fmt.Sprintf("Invalid Samples: %v", samples))
+	}
+	for i, e := range expected {
+		s := samples[i]
+		if s.Name != e.Name || s.Value != e.Value || s.Timestamp != e.Timestamp || fmt.Sprint(s.Labels) != fmt.Sprint(e.Labels) {
+			t.Error(fmt.Sprintf("Invalid Sample: %v Expected: %v", s, e))
+		}
+	}
+	if !math.IsNaN(samples[len(expected)].Value) {
+		t.Error("Sample should be NaN")
+	}
+}
+
+func TestInValidPrometheus(t *testing.T) {
+	invalid := [...]string{"no_value", `bad{label=unquoted} 1`, `open{label="v" 1`, "metric one", "metric 1 2 3", "metric 1 notatime"}
+	for _, line := range invalid {
+		if _, err := ParsePrometheus(strings.NewReader(line)); err == nil {
+			t.Error(fmt.Sprintf("Prometheus is invalid: %s", line))
+		}
+	}
+}
+
+func TestPromSampleToLP(t *testing.T) {
+	sample := PromSample{Name: "ipfs_info", Labels: []Tag{{"commit", ""}, {"path", "a b,c"}}, Value: 1}
+	le := sample.LogEvent(time.Unix(1, 0))
+	le.AddTag(MakeTag("nodeId", "QmNode"))
+	b, err := le.ToLP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `ipfs_info,path=a\ b\,c,nodeId=QmNode duration=0,value=1.000000 1000000000` + "\n"
+	if string(b) != expected {
+		t.Error(fmt.Sprintf("Invalid Line Protocol: %s Expected: %s", b, expected))
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+//An archive is a file of gzip members, each holding a chunk of json lines:
+//a header line describing the source, then one line per raw event as it was received.
+//The index next to it lists where each member starts, so readers can seek by time.
+const (
+	archiveVersion     = 1
+	archiveChunkEvents = 1000             //events per gzip member
+	archiveChunkAge    = 10 * time.Second //longest a member stays open
+	archiveIndexSuffix = ".idx"
+)
+
+//First line of an archive
+type ArchiveHeader struct {
+	Version int       `json:"version"`
+	Name    string    `json:"name"`
+	NodeId  string    `json:"nodeId"`
+	Source  string    `json:"source"`
+	Started time.Time `json:"started"`
+}
+
+//An event of the log tail stream and when it was received
+type ArchiveEvent struct {
+	Received time.Time       `json:"received"`
+	Event    json.RawMessage `json:"event"`
+}
+
+//Where a gzip member of an archive starts and which events it holds
+type ArchiveChunk struct {
+	Offset int64     `json:"offset"`
+	Length int64     `json:"length"`
+	Events int       `json:"events"`
+	First  time.Time `json:"first"`
+	Last   time.Time `json:"last"`
+}
+
+//Counts the bytes written to the archive, so members can be indexed
+type countingWriter struct {
+	w *bufio.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+//Writes the raw events of a proxy into an archive
+type Recorder struct {
+	mu     sync.Mutex
+	Path   string
+	file   *os.File
+	out    *countingWriter
+	index  *os.File
+	gz     *gzip.Writer //nil between members
+	chunk  ArchiveChunk
+	timer  *time.Timer //finishes the open member once it is archiveChunkAge old
+	events uint64
+}
+
+func CreateRecorder(path string, header ArchiveHeader) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	index, err := os.OpenFile(path+archiveIndexSuffix, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	r := &Recorder{
+		Path:  path,
+		file:  file,
+		out:   &countingWriter{w: bufio.NewWriter(file)},
+		index: index,
+	}
+	header.Version = archiveVersion
+	r.mu.Lock()
+	r.startChunk(header.Started)
+	r.mu.Unlock()
+	if err := json.NewEncoder(r.gz).Encode(header); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+//Open a gzip member, it is finished by the timer if no event comes to fill it
+func (r *Recorder) startChunk(first time.Time) {
+	r.gz = gzip.NewWriter(r.out)
+	r.chunk = ArchiveChunk{Offset: r.out.n, First: first, Last: first}
+	offset := r.chunk.Offset
+	r.timer = time.AfterFunc(archiveChunkAge, func() {
+		r.finishAged(offset)
+	})
+}
+
+//Finish the member at offset, unless it was finished already
+func (r *Recorder) finishAged(offset int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil || r.gz == nil || r.chunk.Offset != offset {
+		return
+	}
+	if err := r.finishChunk(); err != nil {
+		errlog.Printf("Record: %s error: %v", r.Path, err)
+	}
+}
+
+//Append a raw event to the archive
+func (r *Recorder) Record(raw []byte, received time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return errors.New("Recorder closed")
+	}
+	if r.gz == nil {
+		r.startChunk(received)
+	}
+	b, err := json.Marshal(ArchiveEvent{Received: received, Event: json.RawMessage(raw)})
+	if err != nil {
+		return err
+	}
+	if _, err := r.gz.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	r.chunk.Events++
+	r.chunk.Last = received
+	r.events++
+	if r.chunk.Events >= archiveChunkEvents || received.Sub(r.chunk.First) >= archiveChunkAge {
+		return r.finishChunk()
+	}
+	return nil
+}
+
+//Close the current gzip member, flush it to disk and index it
+func (r *Recorder) finishChunk() error {
+	if r.gz == nil {
+		return nil
+	}
+	r.timer.Stop()
+	if err := r.gz.Close(); err != nil {
+		return err
+	}
+	r.gz = nil
+	if err := r.out.w.Flush(); err != nil {
+		return err
+	}
+	r.chunk.Length = r.out.n - r.chunk.Offset
+	b, err := json.Marshal(r.chunk)
+	if err != nil {
+		return err
+	}
+	_, err = r.index.Write(append(b, '\n'))
+	return err
+}
+
+//Number of events recorded so far
+func (r *Recorder) Events() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.events
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.finishChunk()
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := r.index.Close(); err == nil {
+		err = cerr
+	}
+	r.file = nil
+	return err
+}
+
+//Default archive of a proxy, in the working directory of the daemon
+func defaultArchivePath(name string) string {
+	return fmt.Sprintf("%s-%s.ipfslog.gz", name, time.Now().Format("20060102-150405"))
+}
+
+//Start writing the raw events of the proxy into an archive, independent of its sink
+func (lp *LogProxy) StartRecording(path string) (string, error) {
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+	if lp.Source.isPrometheus() {
+		return "", errors.New(fmt.Sprintf("ERROR - Source: %s has no event log to record", lp.Name))
+	}
+	if lp.Source.isReplay() {
+		return "", errors.New(fmt.Sprintf("ERROR - Source: %s replays an archive which is already recorded", lp.Name))
+	}
+	if lp.recorder != nil {
+		return "", errors.New(fmt.Sprintf("ERROR - Source: %s already recording to %s", lp.Name, lp.recorder.Path))
+	}
+	if len(path) == 0 {
+		path = defaultArchivePath(lp.Name)
+	}
+	recorder, err := CreateRecorder(path, ArchiveHeader{
+		Name:    lp.Name,
+		NodeId:  lp.NodeId,
+		Source:  lp.Source.String(),
+		Started: time.Now(),
+	})
+	if err != nil {
+		return "", err
+	}
+	lp.recorder = recorder
+	infolog.Printf("Recording Name: %s to: %s\n", lp.Name, path)
+	return path, nil
+}
+
+//Stop recording, returns the archive written
+func (lp *LogProxy) StopRecording() (string, error) {
+	lp.mu.Lock()
+	recorder := lp.recorder
+	lp.recorder = nil
+	lp.mu.Unlock()
+	if recorder == nil {
+		return "", errors.New(fmt.Sprintf("ERROR - Source: %s is not recording", lp.Name))
+	}
+	err := recorder.Close()
+	infolog.Printf("Recorded Name: %s events: %d to: %s\n", lp.Name, recorder.Events(), recorder.Path)
+	return recorder.Path, err
+}
+
+func (lp *LogProxy) record(raw []byte, received time.Time) {
+	lp.mu.RLock()
+	recorder := lp.recorder
+	lp.mu.RUnlock()
+	if recorder == nil {
+		return
+	}
+	if err := recorder.Record(raw, received); err != nil {
+		errlog.Printf("Record Name: %s error: %v", lp.Name, err)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecorderChunks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.ipfslog.gz")
+	start := time.Unix(1700000000, 0)
+	r, err := CreateRecorder(path, ArchiveHeader{Name: "QmNode", NodeId: "QmNode", Source: "127.0.0.1:5001", Started: start})
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := archiveChunkEvents + 10
+	for i := 0; i < total; i++ {
+		raw := fmt.Sprintf(`{"event":"e%d","system":"dht"}`, i)
+		if err := r.Record([]byte(raw), start.Add(time.Duration(i)*time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := ioutil.ReadFile(path + archiveIndexSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chunks []ArchiveChunk
+	dec := json.NewDecoder(bytes.NewReader(index))
+	for {
+		var c ArchiveChunk
+		if err := dec.Decode(&c); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, c)
+	}
+	if len(chunks) != 2 || chunks[0].Events != archiveChunkEvents || chunks[1].Events != 10 {
+		t.Fatal(fmt.Sprintf("Invalid Index: %v", chunks))
+	}
+
+	//every member can be read on its own from its offset
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	file.Seek(chunks[1].Offset, io.SeekStart)
+	gz, err := gzip.NewReader(io.LimitReader(file, chunks[1].Length))
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanner := bufio.NewScanner(gz)
+	scanner.Scan()
+	var ev ArchiveEvent
+	if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf(`{"event":"e%d","system":"dht"}`, archiveChunkEvents)
+	if string(ev.Event) != expected || !ev.Received.Equal(chunks[1].First) {
+		t.Error(fmt.Sprintf("Invalid Event: %s %v Expected: %s %v", ev.Event, ev.Received, expected, chunks[1].First))
+	}
+
+	//and the whole file reads as one gzip stream, starting with the header
+	file.Seek(0, io.SeekStart)
+	gz, err = gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanner = bufio.NewScanner(gz)
+	lines := 0
+	for scanner.Scan() {
+		if lines == 0 {
+			var header ArchiveHeader
+			if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header.NodeId != "QmNode" || header.Version != archiveVersion {
+				t.Error(fmt.Sprintf("Invalid Header: %s", scanner.Bytes()))
+			}
+		}
+		lines++
+	}
+	if lines != total+1 {
+		t.Error(fmt.Sprintf("Invalid Lines: %d Expected: %d", lines, total+1))
+	}
+}
+
+func TestRecorderExistingIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.ipfslog.gz")
+	if err := ioutil.WriteFile(path+archiveIndexSuffix, []byte("{}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateRecorder(path, ArchiveHeader{Name: "QmNode", Started: time.Now()}); err == nil {
+		t.Fatal("Recorder overwrote an index")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error(fmt.Sprintf("Archive left behind: %v", err))
+	}
+	if index, _ := ioutil.ReadFile(path + archiveIndexSuffix); string(index) != "{}\n" {
+		t.Error(fmt.Sprintf("Index changed: %s", index))
+	}
+}
+
+func TestRecordWithoutEventLog(t *testing.T) {
+	dir := t.TempDir()
+	for _, source := range []Source{{Type: SourcePrometheus}, {Type: SourceReplay, File: "node.ipfslog.gz"}} {
+		lp := &LogProxy{Name: "QmNode", Source: source}
+		path := filepath.Join(dir, source.Type+".ipfslog.gz")
+		if _, err := lp.StartRecording(path); err == nil {
+			t.Error(fmt.Sprintf("Recording a %s source", source.Type))
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Error(fmt.Sprintf("Archive of a %s source created: %v", source.Type, err))
+		}
+	}
+}
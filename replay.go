@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	replaySpeedMax = "max"            //replay as fast as the sink takes the events
+	replayMaxLine  = 16 * 1024 * 1024 //longest event a replayed file may hold
+)
+
+func (s Source) isReplay() bool {
+	return s.Type == SourceReplay
+}
+
+//Speed multiplier of a replay, 1 if unset, 0 for as fast as possible
+func parseReplaySpeed(speed string) (float64, error) {
+	switch strings.ToLower(speed) {
+	case "":
+		return 1, nil
+	case replaySpeedMax:
+		return 0, nil
+	}
+	v, err := strconv.ParseFloat(strings.TrimSuffix(speed, "x"), 64)
+	if err != nil || v <= 0 || math.IsInf(v, 0) {
+		return 0, errors.New(fmt.Sprintf("invalid speed: %s, expected a multiplier like 1, 10x or %s", speed, replaySpeedMax))
+	}
+	return v, nil
+}
+
+//An event read back for replay and when it first happened
+type ReplayEvent struct {
+	Event LogEvent
+	Time  time.Time //zero if the event has no time
+}
+
+//Reads the events of an archive, or of a jsonl file holding either raw events
+//of the log tail or events as written by the json sink, gzipped or not
+type ReplayReader struct {
+	Header    *ArchiveHeader //nil unless the file is an archive
+	LogFormat string         //of the raw events, detected if empty
+	file      *os.File
+	scanner   *bufio.Scanner
+	pending   []byte //first line, when it is not an archive header
+	line      int
+}
+
+func OpenReplay(path string) (*ReplayReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(file)
+	var r io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		//reads every gzip member of an archive
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		r = gz
+	}
+	rr := &ReplayReader{
+		file:    file,
+		scanner: bufio.NewScanner(r),
+	}
+	rr.scanner.Buffer(make([]byte, 64*1024), replayMaxLine)
+	first, err := rr.readLine()
+	if err == io.EOF {
+		return rr, nil
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	var header ArchiveHeader
+	if isArchiveHeader(first) && json.Unmarshal(first, &header) == nil {
+		rr.Header = &header
+	} else {
+		rr.pending = first
+	}
+	return rr, nil
+}
+
+//Returns the next non empty line, io.EOF at the end of the file
+func (rr *ReplayReader) readLine() ([]byte, error) {
+	for rr.scanner.Scan() {
+		rr.line++
+		line := bytes.TrimSpace(rr.scanner.Bytes())
+		if len(line) != 0 {
+			return append([]byte(nil), line...), nil
+		}
+	}
+	if err := rr.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+//A line of a replayed file which is not an event, the lines after it can still be read
+type ReplayLineError struct {
+	Line int
+	Err  error
+}
+
+func (e *ReplayLineError) Error() string {
+	return fmt.Sprintf("Replay line %d: %v", e.Line, e.Err)
+}
+
+//Returns the next event, io.EOF at the end of the file
+func (rr *ReplayReader) Next() (ReplayEvent, error) {
+	line := rr.pending
+	rr.pending = nil
+	if line == nil {
+		var err error
+		if line, err = rr.readLine(); err != nil {
+			return ReplayEvent{}, err
+		}
+	}
+	ev, err := parseReplayLine(line, rr.LogFormat)
+	if err != nil {
+		return ev, &ReplayLineError{Line: rr.line, Err: err}
+	}
+	return ev, nil
+}
+
+func (rr *ReplayReader) Close() error {
+	return rr.file.Close()
+}
+
+func isArchiveHeader(line []byte) bool {
+	var keys map[string]json.RawMessage
+	if json.Unmarshal(line, &keys) != nil {
+		return false
+	}
+	_, version := keys["version"]
+	_, event := keys["event"]
+	return version && !event
+}
+
+//An archived event is paced by when it was received, the others by their own time
+//Raw events are normalized from the format of the node, json sink output already was
+func parseReplayLine(line []byte, format string) (ReplayEvent, error) {
+	var ev ReplayEvent
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(line, &keys); err != nil {
+		return ev, err
+	}
+	//a raw event has an event key too, but it holds the name of the event
+	if raw, ok := keys["event"]; ok && isJSONObject(raw) {
+		var archived ArchiveEvent
+		if err := json.Unmarshal(line, &archived); err != nil {
+			return ev, err
+		}
+		event, err := DecodeLogEvent(archived.Event)
+		if err != nil {
+			return ev, err
+		}
+		event.normalize(format)
+		ev.Event = event
+		ev.Time = archived.Received
+	} else if raw, ok := keys["message"]; ok && isJSONObject(raw) {
+		if err := json.Unmarshal(line, &ev.Event); err != nil {
+			return ev, err
+		}
+		//the proxy tags the events with the node they are replayed for
+		tags := ev.Event.Tags[:0]
+		for _, tag := range ev.Event.Tags {
+			if tag.Name != "nodeId" {
+				tags = append(tags, tag)
+			}
+		}
+		ev.Event.Tags = tags
+	} else {
+		event, err := DecodeLogEvent(line)
+		if err != nil {
+			return ev, err
+		}
+		event.normalize(format)
+		ev.Event = event
+	}
+	if ev.Event.Message == nil {
+		return ev, errors.New("no event")
+	}
+	if ev.Time.IsZero() {
+		ev.Time = ev.Event.Time
+	}
+	return ev, nil
+}
+
+func isJSONObject(raw json.RawMessage) bool {
+	raw = bytes.TrimSpace(raw)
+	return len(raw) != 0 && raw[0] == '{'
+}
+
+//Node the replayed events came from, named in the header of an archive,
+//else the events are tagged with the name of the file
+func replayNodeId(path string) (string, error) {
+	rr, err := OpenReplay(path)
+	if err != nil {
+		return "", err
+	}
+	defer rr.Close()
+	if rr.Header != nil && len(rr.Header.NodeId) != 0 {
+		return rr.Header.NodeId, nil
+	}
+	base := filepath.Base(path)
+	for ext := filepath.Ext(base); len(ext) != 0; ext = filepath.Ext(base) {
+		base = strings.TrimSuffix(base, ext)
+	}
+	return base, nil
+}
+
+//Re-emit the events of the replayed file -> Filter, paced by when they first happened.
+//Once the file ends the events left in the pipeline are written and the proxy removes itself.
+func (lp *LogProxy) ReplayEvents(rr *ReplayReader) {
+	defer lp.readers.Done()
+	defer rr.Close()
+	speed, _ := parseReplaySpeed(lp.Source.Speed)
+	infolog.Printf("Replay Open: %s Name: %s\n", lp.source(), lp.Name)
+	var first, start time.Time
+	var events uint64
+	for {
+		//time spent paused does not count against the pace
+		paused := time.Now()
+		if !lp.waitResume() {
+			infolog.Printf("Replay Close: %s Name: %s\n", lp.source(), lp.Name)
+			return
+		}
+		start = start.Add(time.Since(paused))
+		ev, err := rr.Next()
+		if err == io.EOF {
+			infolog.Printf("Replay Finished: %s events: %d Name: %s\n", lp.source(), events, lp.Name)
+			return
+		}
+		var lineErr *ReplayLineError
+		if errors.As(err, &lineErr) {
+			errlog.Printf("Replay Source: %s skipped: %v", lp.source(), err)
+			continue
+		}
+		if err != nil {
+			errlog.Printf("Replay Source: %s error: %v", lp.source(), err)
+			return
+		}
+		if !ev.Time.IsZero() {
+			if first.IsZero() {
+				first = ev.Time
+				start = time.Now()
+			}
+			if speed > 0 && !lp.sleepUntil(start.Add(time.Duration(float64(ev.Time.Sub(first))/speed))) {
+				infolog.Printf("Replay Close: %s Name: %s\n", lp.source(), lp.Name)
+				return
+			}
+			//shifted so the replay starts now, the events keep their spacing
+			if lp.Source.RewriteTime {
+				ev.Event.SetTime(start.Add(ev.Time.Sub(first)))
+			}
+		}
+		events++
+		lp.Stats.read()
+		lp.push(lp.Inbound, lp.inSpill, ev.Event)
+	}
+}
+
+//Returns false if the proxy was closed or stopped reading first
+func (lp *LogProxy) sleepUntil(due time.Time) bool {
+	wait := time.Until(due)
+	if wait <= 0 {
+		return true
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-lp.ctx.Done():
+		return false
+	case <-lp.stopRead:
+		return false
+	}
+}
+
+//Remove a replay from the collection once its events are written
+func (lp *LogProxy) finishReplay() {
+	select {
+	case <-lp.writerDone:
+	case <-lp.ctx.Done():
+		return
+	}
+	//stopped by a shutdown, which removes it
+	if !lp.reading() {
+		return
+	}
+	if removeProxy(lp) {
+		lp.Close()
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReplayArchive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.ipfslog.gz")
+	start := time.Unix(1700000000, 0).UTC()
+	r, err := CreateRecorder(path, ArchiveHeader{Name: "QmNode", NodeId: "QmNode", Started: start})
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := archiveChunkEvents + 10
+	for i := 0; i < total; i++ {
+		raw := fmt.Sprintf(`{"event":"e%d","system":"dht","time":"2017-11-17T22:09:10Z"}`, i)
+		if err := r.Record([]byte(raw), start.Add(time.Duration(i)*time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rr, err := OpenReplay(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rr.Close()
+	if rr.Header == nil || rr.Header.NodeId != "QmNode" {
+		t.Fatal(fmt.Sprintf("Invalid Header: %v", rr.Header))
+	}
+	n := 0
+	for {
+		ev, err := rr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		//archived events are paced by when they were received
+		if ev.Event.Message["event"] != fmt.Sprintf("e%d", n) || !ev.Time.Equal(start.Add(time.Duration(n)*time.Millisecond)) {
+			t.Fatal(fmt.Sprintf("Invalid Event %d: %v at %s", n, ev.Event.Message, ev.Time))
+		}
+		n++
+	}
+	if n != total {
+		t.Error(fmt.Sprintf("Replayed %d events, expected %d", n, total))
+	}
+}
+
+func TestReplayJSONL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "incident.jsonl")
+	lines := `{"event":"handleAddProvider","system":"dht","time":"2017-11-17T22:09:10.5Z"}
+
+{"message":{"event":"swarmDialAttemptSync","system":"swarm2","time":"2017-11-17T22:09:11Z"},"tags":[{"Name":"nodeId","Value":"QmOld"},{"Name":"dc","Value":"ams"}]}
+`
+	if err := ioutil.WriteFile(path, []byte(lines), 0644); err != nil {
+		t.Fatal(err)
+	}
+	nodeId, err := replayNodeId(path)
+	if err != nil || nodeId != "incident" {
+		t.Error(fmt.Sprintf("Invalid NodeId: %s %v", nodeId, err))
+	}
+
+	rr, err := OpenReplay(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rr.Close()
+	if rr.Header != nil {
+		t.Error(fmt.Sprintf("Unexpected Header: %v", rr.Header))
+	}
+	ev, err := rr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Event.Message["event"] != "handleAddProvider" || ev.Time.Format(time.RFC3339Nano) != "2017-11-17T22:09:10.5Z" {
+		t.Error(fmt.Sprintf("Invalid Raw Event: %v at %s", ev.Event.Message, ev.Time))
+	}
+	ev, err = rr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the node id tag of the sink output is replaced by the proxy's
+	if ev.Event.Message["system"] != "swarm2" || len(ev.Event.Tags) != 1 || ev.Event.Tags[0].Name != "dc" {
+		t.Error(fmt.Sprintf("Invalid Sink Event: %v %v", ev.Event.Message, ev.Event.Tags))
+	}
+	if _, err := rr.Next(); err != io.EOF {
+		t.Error(fmt.Sprintf("Expected EOF, got: %v", err))
+	}
+}
+
+func TestParseReplaySpeed(t *testing.T) {
+	valid := map[string]float64{"": 1, "1": 1, "10x": 10, "0.5": 0.5, "max": 0, "MAX": 0}
+	for s, expected := range valid {
+		if speed, err := parseReplaySpeed(s); err != nil || speed != expected {
+			t.Error(fmt.Sprintf("Speed %q: %v %v, expected %v", s, speed, err, expected))
+		}
+	}
+	for _, s := range []string{"0", "-2", "fast", "x"} {
+		if _, err := parseReplaySpeed(s); err == nil {
+			t.Error(fmt.Sprintf("Speed %q should be invalid", s))
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"expvar"
+	"runtime"
+	"sort"
+	"time"
+)
+
+//Measurement used for the events ipfs-metricsd emits about itself
+const internalMeasurement = "ipfs_metrics_internal"
+
+//What ipfs-metricsd measures about itself, published on /debug/vars
+type SelfMetrics struct {
+	Time            time.Time     `json:"time"`
+	Goroutines      int           `json:"goroutines"`
+	MemAlloc        uint64        `json:"memAlloc"`
+	MemHeapInuse    uint64        `json:"memHeapInuse"`
+	MemSys          uint64        `json:"memSys"`
+	NumGC           uint32        `json:"numGC"`
+	ProxyCount      int           `json:"proxyCount"`
+	Proxies         []StatsResult `json:"proxies"`
+	TotalRead       uint64        `json:"totalRead"`
+	TotalWritten    uint64        `json:"totalWritten"`
+	TotalDropped    uint64        `json:"totalDropped"`
+	TotalReconnects uint64        `json:"totalReconnects"`
+}
+
+func init() {
+	expvar.Publish("ipfs_metrics", expvar.Func(func() interface{} {
+		return collectSelfMetrics()
+	}))
+}
+
+func collectSelfMetrics() SelfMetrics {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	sm := SelfMetrics{
+		Time:         time.Now(),
+		Goroutines:   runtime.NumGoroutine(),
+		MemAlloc:     mem.Alloc,
+		MemHeapInuse: mem.HeapInuse,
+		MemSys:       mem.Sys,
+		NumGC:        mem.NumGC,
+	}
+	for _, lp := range proxies() {
+		sr := lp.StatsSnapshot()
+		sm.Proxies = append(sm.Proxies, sr)
+		sm.TotalRead += sr.EventsRead
+		sm.TotalWritten += sr.EventsWritten
+		sm.TotalDropped += sr.EventsDropped
+		sm.TotalReconnects += sr.Reconnects
+	}
+	sort.Slice(sm.Proxies, func(i, j int) bool {
+		return sm.Proxies[i].Name < sm.Proxies[j].Name
+	})
+	sm.ProxyCount = len(sm.Proxies)
+	return sm
+}
+
+//Turn the self metrics into log events, one for the process and one per proxy
+func (sm SelfMetrics) LogEvents() []LogEvent {
+	ts := sm.Time.Format(time.RFC3339Nano)
+	process := NewLogEvent(map[string]interface{}{
+		"system": internalMeasurement,
+		"event":  "process",
+		"time":   ts,
+	})
+	process.ExtraFields = map[string]interface{}{
+		"goroutines":     sm.Goroutines,
+		"mem_alloc":      sm.MemAlloc,
+		"mem_heap_inuse": sm.MemHeapInuse,
+		"mem_sys":        sm.MemSys,
+		"num_gc":         uint64(sm.NumGC),
+		"proxies":        sm.ProxyCount,
+	}
+	events := []LogEvent{process}
+	for _, sr := range sm.Proxies {
+		fields := map[string]interface{}{
+			"events_read":      sr.EventsRead,
+			"events_filtered":  sr.EventsFiltered,
+			"events_written":   sr.EventsWritten,
+			"events_dropped":   sr.EventsDropped,
+			"encode_errors":    sr.EncodeErrors,
+			"sink_errors":      sr.SinkErrors,
+			"bytes_written":    sr.BytesWritten,
+			"inbound_len":      sr.Inbound.Len,
+			"outbound_len":     sr.Outbound.Len,
+			"batch_size_last":  sr.LastBatchSize,
+			"batch_size_avg":   sr.AvgBatchSize,
+			"write_latency_ns": int64(sr.writeLatency),
+			"reconnects":       sr.Reconnects,
+			"queue_drops":      sr.QueueDrops,
+			"events_spilled":   sr.EventsSpilled,
+			"spill_pending":    sr.SpillPending,
+		}
+		if sr.clockOffset != nil {
+			fields["clock_offset_ns"] = int64(*sr.clockOffset)
+		}
+		event := NewLogEvent(map[string]interface{}{
+			"system": internalMeasurement,
+			"event":  "proxy",
+			"time":   ts,
+		})
+		event.Tags = []Tag{MakeTag("proxy", sr.Name)}
+		event.ExtraFields = fields
+		events = append(events, event)
+	}
+	return events
+}
+
+//Returns the sinks of the proxies, one per destination and format, as proxies
+//with separately parsed configs hold equal sinks with options of their own
+func selfMetricSinks(lps []*LogProxy) []Sink {
+	seen := make(map[string]bool)
+	var sinks []Sink
+	for _, lp := range lps {
+		lp.mu.RLock()
+		sink := lp.Sink
+		lp.mu.RUnlock()
+		//a trace only holds spans
+		if sink.Format == "trace" {
+			continue
+		}
+		key := sink.Format + "|" + sink.String()
+		if !seen[key] {
+			seen[key] = true
+			sinks = append(sinks, sink)
+		}
+	}
+	return sinks
+}
+
+//Periodically write the self metrics to every sink in the collection
+func emitSelfMetrics(interval time.Duration) {
+	for range time.Tick(interval) {
+		events := collectSelfMetrics().LogEvents()
+		for _, sink := range selfMetricSinks(proxies()) {
+			var batch []byte
+			for _, event := range events {
+				b, err := encodeEvent(sink, event, "")
+				if err != nil {
+					errlog.Println("Self metrics marshal: ", err)
+					continue
+				}
+				batch = append(batch, b...)
+			}
+			if err := writeSink(sink, batch); err != nil {
+				errlog.Printf("Self metrics Sink: %s write: %v", sink, err)
+			}
+		}
+	}
+}
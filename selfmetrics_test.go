@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSelfMetricSinks(t *testing.T) {
+	influx := Sink{Address: "127.0.0.1", Port: "8086", Format: "lineprotocol", Flatten: &Flatten{}}
+	lps := []*LogProxy{
+		{Name: "QmA", Sink: influx},
+		//the same destination from another config
+		{Name: "QmB", Sink: Sink{Address: "127.0.0.1", Port: "8086", Format: "lineprotocol", Flatten: &Flatten{}}},
+		{Name: "QmC", Sink: Sink{Address: "127.0.0.1", Port: "8086", Format: "json"}},
+		{Name: "QmD", Sink: Sink{Format: "trace", File: "trace.json"}},
+	}
+	sinks := selfMetricSinks(lps)
+	if len(sinks) != 2 || sinks[0].Format != "lineprotocol" || sinks[1].Format != "json" {
+		t.Error(fmt.Sprintf("Invalid Sinks: %v", sinks))
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//What happened to the events of a proxy during shutdown
+type ShutdownResult struct {
+	Name      string
+	Flushed   uint64 //written to the sink during shutdown
+	Abandoned uint64 //read but never written, filtered or dropped
+	Err       error
+}
+
+//Stop reading from the source and write out everything already read,
+//whatever is still in the pipeline when ctx expires is abandoned
+func (lp *LogProxy) Shutdown(ctx context.Context) ShutdownResult {
+	ps := &lp.Stats
+	res := ShutdownResult{Name: lp.Name}
+	before := atomic.LoadUint64(&ps.eventsWritten)
+
+	infolog.Printf("Draining Connection Name: %s\n", lp.Name)
+	close(lp.stopRead)
+	lp.closeSource()
+	select {
+	case <-lp.writerDone:
+	case <-ctx.Done():
+		res.Err = ctx.Err()
+	}
+	//a write still in flight is not waited for
+	lp.Close()
+
+	res.Flushed = atomic.LoadUint64(&ps.eventsWritten) - before
+	accounted := atomic.LoadUint64(&ps.eventsWritten) + atomic.LoadUint64(&ps.eventsFiltered) + atomic.LoadUint64(&ps.eventsDropped)
+	if read := atomic.LoadUint64(&ps.eventsRead); read > accounted {
+		res.Abandoned = read - accounted
+	}
+	return res
+}
+
+//Stop accepting commands, then drain every proxy in the collection
+//within the timeout, logging a summary of what was flushed or abandoned
+func shutdownDaemon(server *http.Server, timeout time.Duration) []ShutdownResult {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		errlog.Println("Shutdown control api: ", err)
+	}
+
+	stopDiscovery()
+	lps := proxies()
+	results := make([]ShutdownResult, len(lps))
+	var wg sync.WaitGroup
+	for i, lp := range lps {
+		wg.Add(1)
+		go func(i int, lp *LogProxy) {
+			defer wg.Done()
+			results[i] = lp.Shutdown(ctx)
+			deleteProxy(lp.Name)
+		}(i, lp)
+	}
+	wg.Wait()
+	//after the proxies, so the events they drained are correlated too
+	stopCorrelation()
+	closeSinkFiles()
+
+	var flushed, abandoned uint64
+	for _, res := range results {
+		flushed += res.Flushed
+		abandoned += res.Abandoned
+		if res.Err != nil {
+			errlog.Printf("Shutdown Name: %s flushed: %d abandoned: %d error: %v\n", res.Name, res.Flushed, res.Abandoned, res.Err)
+			continue
+		}
+		infolog.Printf("Shutdown Name: %s flushed: %d abandoned: %d\n", res.Name, res.Flushed, res.Abandoned)
+	}
+	infolog.Printf("ipfs-metricsd stopped, sources: %d flushed: %d abandoned: %d\n", len(results), flushed, abandoned)
+	return results
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestShutdownDrain(t *testing.T) {
+	node := newTestNode(t)
+	path := filepath.Join(t.TempDir(), "events.json")
+	//the batch only fills up at shutdown
+	lp := startTestProxy(t, node.Source, Sink{Format: "json", File: path, BatchSize: 10})
+	for i := 1; i <= 3; i++ {
+		node.events <- testNodeEvent(i)
+	}
+	read := eventsRead(lp)
+	waitFor(t, "events", func() bool { return read() == 3 })
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal(fmt.Sprintf("Written Before Shutdown: %v", err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	removeProxy(lp)
+	res := lp.Shutdown(ctx)
+	if res.Err != nil || res.Flushed != 3 || res.Abandoned != 0 {
+		t.Error(fmt.Sprintf("Invalid Shutdown Result: %+v", res))
+	}
+	if n := len(readSinkEvents(t, path)); n != 3 {
+		t.Error(fmt.Sprintf("Invalid Events Written: %d", n))
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+//A file sinks write to, shared by every proxy writing to the same path
+type sinkFile struct {
+	mu     sync.Mutex
+	file   *os.File
+	format string        //every sink writing to the file has the same format
+	trace  *TraceEncoder //numbers the nodes and threads of a trace file
+}
+
+var sinkFiles = struct {
+	sync.Mutex
+	open map[string]*sinkFile
+}{open: make(map[string]*sinkFile)}
+
+//Returns the format of a sink file which is open, so a sink of another format is refused
+func sinkFileFormat(path string) (string, bool) {
+	sinkFiles.Lock()
+	defer sinkFiles.Unlock()
+	if sf, ok := sinkFiles.open[path]; ok {
+		return sf.format, true
+	}
+	return "", false
+}
+
+//Open the file of the sink for appending, once for all proxies.
+//A new trace file starts the json array its events are written to,
+//an existing one is read so the nodes and threads keep their numbers.
+func openSinkFile(sink Sink) (*sinkFile, error) {
+	sinkFiles.Lock()
+	defer sinkFiles.Unlock()
+	if sf, ok := sinkFiles.open[sink.File]; ok {
+		if sf.format != sink.Format {
+			return nil, errors.New(fmt.Sprintf("Sink file: %s is written as %s, not %s", sink.File, sf.format, sink.Format))
+		}
+		return sf, nil
+	}
+	file, err := os.OpenFile(sink.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	sf := &sinkFile{file: file, format: sink.Format}
+	if sink.Format == "trace" {
+		sf.trace = NewTraceEncoder()
+		info, err := file.Stat()
+		if err == nil && info.Size() == 0 {
+			_, err = file.Write([]byte("[\n"))
+		} else if err == nil {
+			err = sf.trace.loadFile(sink.File)
+		}
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	sinkFiles.open[sink.File] = sf
+	return sf, nil
+}
+
+func (sf *sinkFile) Write(b []byte) error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	_, err := sf.file.Write(b)
+	return err
+}
+
+//Close every sink file, once nothing writes to them
+func closeSinkFiles() {
+	sinkFiles.Lock()
+	defer sinkFiles.Unlock()
+	for path, sf := range sinkFiles.open {
+		sf.file.Close()
+		delete(sinkFiles.open, path)
+	}
+}
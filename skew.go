@@ -0,0 +1,89 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+//What is done with the estimated clock offset of a source
+const (
+	ClockSkewEstimate = "estimate" //only estimate it and show it in stats, the default
+	ClockSkewAnnotate = "annotate" //add it to each event as the clock_offset field, in nanoseconds
+	ClockSkewCorrect  = "correct"  //take it off the time of each event
+)
+
+const (
+	clockSkewWindow    = 5 * time.Second //the samples of a window make one estimate
+	clockSkewSmoothing = 0.2             //weight of a new estimate against the ones before
+)
+
+func validClockSkew(mode string) bool {
+	return mode == "" || mode == ClockSkewEstimate || mode == ClockSkewAnnotate || mode == ClockSkewCorrect
+}
+
+//Estimates how far the clock of a node is ahead of the daemon's.
+//An event is received some time after it happened, so of the samples in a window
+//the one furthest ahead of its receipt had the least delay and is the best estimate.
+//The estimates of the windows are smoothed, so one slow window does not move it much.
+type clockSkew struct {
+	mu        sync.Mutex
+	offset    time.Duration
+	known     bool
+	started   time.Time //start of the current window
+	windowMax time.Duration
+	samples   int //in the current window
+}
+
+func (cs *clockSkew) observe(happened, received time.Time) {
+	sample := happened.Sub(received)
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.samples == 0 {
+		cs.started = received
+		cs.windowMax = sample
+	} else if sample > cs.windowMax {
+		cs.windowMax = sample
+	}
+	cs.samples++
+	if received.Sub(cs.started) < clockSkewWindow {
+		return
+	}
+	if cs.known {
+		cs.offset += time.Duration(clockSkewSmoothing * float64(cs.windowMax-cs.offset))
+	} else {
+		cs.offset = cs.windowMax
+		cs.known = true
+	}
+	cs.samples = 0
+}
+
+//Returns the estimated offset, false before any event was observed.
+//Until the first window ends it is the estimate of the samples so far.
+func (cs *clockSkew) Offset() (time.Duration, bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.known {
+		return cs.offset, true
+	}
+	return cs.windowMax, cs.samples != 0
+}
+
+//Estimate the clock offset of the source from an event as it is received,
+//then annotate or correct the event if the source asks for it
+func (lp *LogProxy) adjustClock(event *LogEvent, received time.Time) {
+	happened := event.Time
+	if happened.IsZero() {
+		return
+	}
+	lp.skew.observe(happened, received)
+	offset, _ := lp.skew.Offset()
+	switch lp.Source.ClockSkew {
+	case ClockSkewAnnotate:
+		if event.ExtraFields == nil {
+			event.ExtraFields = make(map[string]interface{})
+		}
+		event.ExtraFields["clock_offset"] = int64(offset)
+	case ClockSkewCorrect:
+		event.SetTime(happened.Add(-offset))
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestClockSkewEstimate(t *testing.T) {
+	var cs clockSkew
+	if _, ok := cs.Offset(); ok {
+		t.Fatal("Offset known before any event")
+	}
+	start := time.Unix(1700000000, 0)
+	//the node is 2s ahead, the events are delayed up to 300ms
+	for i, delay := range []time.Duration{300, 0, 100, 200, 50, 300} {
+		received := start.Add(time.Duration(i) * time.Second)
+		cs.observe(received.Add(2*time.Second-delay*time.Millisecond), received)
+	}
+	if offset, ok := cs.Offset(); !ok || offset != 2*time.Second {
+		t.Fatal(fmt.Sprintf("Invalid Offset: %s %v", offset, ok))
+	}
+	//a window of slow events moves the estimate only a little
+	for i := 0; i < 6; i++ {
+		received := start.Add(time.Duration(10+i) * time.Second)
+		cs.observe(received.Add(time.Second), received)
+	}
+	if offset, _ := cs.Offset(); offset != 1800*time.Millisecond {
+		t.Error(fmt.Sprintf("Invalid Smoothed Offset: %s", offset))
+	}
+}
+
+func TestAdjustClock(t *testing.T) {
+	received := time.Date(2017, 11, 17, 22, 9, 10, 0, time.UTC)
+	newEvent := func() LogEvent {
+		return NewLogEvent(map[string]interface{}{"system": "dht", "event": "findProviders", "time": "2017-11-17T22:09:11.5Z"})
+	}
+
+	lp := &LogProxy{Source: Source{ClockSkew: ClockSkewAnnotate}}
+	event := newEvent()
+	lp.adjustClock(&event, received)
+	if event.ExtraFields["clock_offset"] != int64(1500*time.Millisecond) || event.Message["time"] != "2017-11-17T22:09:11.5Z" {
+		t.Error(fmt.Sprintf("Invalid Annotated Event: %v %v", event.Message, event.ExtraFields))
+	}
+
+	lp = &LogProxy{Source: Source{ClockSkew: ClockSkewCorrect}}
+	event = newEvent()
+	lp.adjustClock(&event, received)
+	if event.Message["time"] != "2017-11-17T22:09:10Z" || event.ExtraFields != nil {
+		t.Error(fmt.Sprintf("Invalid Corrected Event: %v %v", event.Message, event.ExtraFields))
+	}
+
+	//without a time there is nothing to estimate
+	lp = &LogProxy{}
+	event = NewLogEvent(map[string]interface{}{"system": "dht"})
+	lp.adjustClock(&event, received)
+	if _, ok := lp.skew.Offset(); ok {
+		t.Error("Offset estimated from an event without a time")
+	}
+}
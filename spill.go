@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+//A file backed FIFO holding the events that did not fit in a channel
+//when the backpressure policy is spill
+type spillQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	path    string
+	writer  *os.File
+	reader  *os.File
+	buf     *bufio.Reader
+	pending int //events in the file which have not been handed to the channel yet
+	markers map[uint64]*ProxyUpdate
+	nextId  uint64
+	closed  bool
+}
+
+//Updates cannot be written to disk, so only their id is and the update
+//is kept in memory until it is read back
+type spillRecord struct {
+	Marker uint64    `json:"marker,omitempty"`
+	Event  *LogEvent `json:"event,omitempty"`
+}
+
+func newSpillQueue(dir, prefix string) (*spillQueue, error) {
+	f, err := ioutil.TempFile(dir, prefix)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	writer, err := os.OpenFile(f.Name(), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := os.Open(f.Name())
+	if err != nil {
+		writer.Close()
+		return nil, err
+	}
+	sq := &spillQueue{
+		path:    f.Name(),
+		writer:  writer,
+		reader:  reader,
+		buf:     bufio.NewReader(reader),
+		markers: make(map[uint64]*ProxyUpdate),
+	}
+	sq.cond = sync.NewCond(&sq.mu)
+	return sq, nil
+}
+
+func (sq *spillQueue) Len() int {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+	return sq.pending
+}
+
+//Append an event to the end of the queue
+func (sq *spillQueue) Push(event LogEvent) error {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+	if sq.closed {
+		return os.ErrClosed
+	}
+	var rec spillRecord
+	if event.update != nil {
+		sq.nextId++
+		sq.markers[sq.nextId] = event.update
+		rec.Marker = sq.nextId
+	} else {
+		rec.Event = &event
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := sq.writer.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	sq.pending++
+	sq.cond.Signal()
+	return nil
+}
+
+//Read the oldest event, blocking until there is one. The event stays
+//counted as pending until done is called so nothing overtakes it.
+func (sq *spillQueue) next() (LogEvent, error) {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+	for sq.pending == 0 && !sq.closed {
+		sq.cond.Wait()
+	}
+	if sq.closed {
+		return LogEvent{}, os.ErrClosed
+	}
+	line, err := sq.buf.ReadBytes('\n')
+	if err != nil {
+		return LogEvent{}, err
+	}
+	var rec spillRecord
+	if err := json.Unmarshal(line, &rec); err != nil {
+		return LogEvent{}, err
+	}
+	if rec.Marker != 0 {
+		update := sq.markers[rec.Marker]
+		delete(sq.markers, rec.Marker)
+		return LogEvent{update: update}, nil
+	}
+	return *rec.Event, nil
+}
+
+//Mark the event returned by next as handed over, once the queue is
+//empty the file is truncated so it does not grow forever
+func (sq *spillQueue) done() {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+	sq.pending--
+	if sq.pending != 0 {
+		return
+	}
+	if err := sq.writer.Truncate(0); err != nil {
+		errlog.Printf("Spill: %s truncate: %v", sq.path, err)
+		return
+	}
+	sq.reader.Seek(0, io.SeekStart)
+	sq.buf.Reset(sq.reader)
+}
+
+//Move spilled events into the channel until the context is done
+func (sq *spillQueue) drain(ctx context.Context, ch chan LogEvent) {
+	go func() {
+		<-ctx.Done()
+		sq.Close()
+	}()
+	for {
+		event, err := sq.next()
+		if err == os.ErrClosed {
+			return
+		}
+		if err != nil {
+			errlog.Printf("Spill: %s read: %v", sq.path, err)
+			sq.done()
+			continue
+		}
+		select {
+		case ch <- event:
+			sq.done()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+//Close and remove the spill file, returns the number of events abandoned
+func (sq *spillQueue) Close() int {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+	if sq.closed {
+		return 0
+	}
+	sq.closed = true
+	sq.cond.Broadcast()
+	sq.writer.Close()
+	sq.reader.Close()
+	os.Remove(sq.path)
+	return sq.pending
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestSpillQueueOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sq, err := newSpillQueue(dir, "test-")
+	if err != nil {
+		t.Fatal("Failed To Make Spill Queue For Test")
+	}
+	defer sq.Close()
+
+	update := &ProxyUpdate{}
+	for i := 0; i < 3; i++ {
+		sq.Push(LogEvent{Message: map[string]interface{}{"event": fmt.Sprint(i)}})
+	}
+	sq.Push(LogEvent{update: update})
+	sq.Push(LogEvent{Message: map[string]interface{}{"event": "3"}})
+	if sq.Len() != 5 {
+		t.Error(fmt.Sprintf("Invalid Len: %d Expected: %d", sq.Len(), 5))
+	}
+
+	for i := 0; i < 5; i++ {
+		event, err := sq.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sq.done()
+		if i == 3 {
+			if event.update != update {
+				t.Error("Update not read back in order")
+			}
+			continue
+		}
+		expected := fmt.Sprint(i)
+		if i == 4 {
+			expected = "3"
+		}
+		if event.Message["event"] != expected {
+			t.Error(fmt.Sprintf("Invalid Event: %v Expected: %v", event.Message["event"], expected))
+		}
+	}
+	if sq.Len() != 0 {
+		t.Error(fmt.Sprintf("Invalid Len: %d Expected: %d", sq.Len(), 0))
+	}
+
+	//the file is reused once emptied
+	sq.Push(LogEvent{Message: map[string]interface{}{"event": "again"}})
+	event, err := sq.next()
+	if err != nil || event.Message["event"] != "again" {
+		t.Error(fmt.Sprintf("Invalid Event After Truncate: %v %v", event.Message, err))
+	}
+}
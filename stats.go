@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+//Counters kept by each proxy, only accessed through sync/atomic
+type ProxyStats struct {
+	eventsRead     uint64
+	eventsFiltered uint64
+	eventsWritten  uint64
+	eventsDropped  uint64
+	encodeErrors   uint64
+	sinkErrors     uint64
+	bytesWritten   uint64
+	writes         uint64 //batches written
+	writeNanos     uint64 //total time spent writing to the sink
+	lastWriteNanos int64
+	lastBatchSize  int64
+	reconnects     uint64
+	queueDrops     uint64 //dropped by the backpressure policy
+	eventsSpilled  uint64
+	lastEvent      int64 //unix nano of the last event read
+}
+
+func (ps *ProxyStats) read() {
+	atomic.AddUint64(&ps.eventsRead, 1)
+	atomic.StoreInt64(&ps.lastEvent, time.Now().UnixNano())
+}
+
+func (ps *ProxyStats) filtered() {
+	atomic.AddUint64(&ps.eventsFiltered, 1)
+}
+
+func (ps *ProxyStats) dropped(n int) {
+	atomic.AddUint64(&ps.eventsDropped, uint64(n))
+}
+
+func (ps *ProxyStats) encodeError() {
+	atomic.AddUint64(&ps.encodeErrors, 1)
+	ps.dropped(1)
+}
+
+//A failed write drops the whole batch
+func (ps *ProxyStats) sinkError(events int) {
+	atomic.AddUint64(&ps.sinkErrors, 1)
+	ps.dropped(events)
+}
+
+func (ps *ProxyStats) written(events, bytes int, latency time.Duration) {
+	atomic.AddUint64(&ps.eventsWritten, uint64(events))
+	atomic.AddUint64(&ps.bytesWritten, uint64(bytes))
+	atomic.AddUint64(&ps.writes, 1)
+	atomic.AddUint64(&ps.writeNanos, uint64(latency))
+	atomic.StoreInt64(&ps.lastWriteNanos, int64(latency))
+	atomic.StoreInt64(&ps.lastBatchSize, int64(events))
+}
+
+func (ps *ProxyStats) queueDrop() {
+	atomic.AddUint64(&ps.queueDrops, 1)
+	ps.dropped(1)
+}
+
+func (ps *ProxyStats) spilled() {
+	atomic.AddUint64(&ps.eventsSpilled, 1)
+}
+
+func (ps *ProxyStats) reconnect() {
+	atomic.AddUint64(&ps.reconnects, 1)
+}
+
+type QueueStats struct {
+	Len int `json:"len"`
+	Cap int `json:"cap"`
+}
+
+type StatsResult struct {
+	Name             string     `json:"name"`
+	State            string     `json:"state"`
+	Source           string     `json:"source"`
+	Sink             string     `json:"sink"`
+	Tags             []Tag      `json:"tags,omitempty"`
+	EventsRead       uint64     `json:"eventsRead"`
+	EventsFiltered   uint64     `json:"eventsFiltered"`
+	EventsWritten    uint64     `json:"eventsWritten"`
+	EventsDropped    uint64     `json:"eventsDropped"`
+	EncodeErrors     uint64     `json:"encodeErrors"`
+	SinkErrors       uint64     `json:"sinkErrors"`
+	BytesWritten     uint64     `json:"bytesWritten"`
+	LastEvent        *time.Time `json:"lastEvent,omitempty"`
+	Inbound          QueueStats `json:"inbound"`
+	Outbound         QueueStats `json:"outbound"`
+	LastWriteLatency string     `json:"lastWriteLatency"`
+	AvgWriteLatency  string     `json:"avgWriteLatency"`
+	LastBatchSize    int64      `json:"lastBatchSize"`
+	AvgBatchSize     float64    `json:"avgBatchSize"`
+	Reconnects       uint64     `json:"reconnects"`
+	QueueDrops       uint64     `json:"queueDrops"`
+	EventsSpilled    uint64     `json:"eventsSpilled"`
+	SpillPending     int        `json:"spillPending"`
+	ClockOffset      string     `json:"clockOffset,omitempty"` //how far the clock of the node is ahead, once estimated
+	writeLatency     time.Duration
+	clockOffset      *time.Duration
+}
+
+//Take a snapshot of the proxies counters and channel occupancy
+func (lp *LogProxy) StatsSnapshot() StatsResult {
+	ps := &lp.Stats
+	state := lp.State()
+	lp.mu.RLock()
+	sr := StatsResult{
+		Name:   lp.Name,
+		State:  state,
+		Source: lp.Source.String(),
+		Sink:   lp.Sink.String(),
+		Tags:   lp.Source.Tags,
+	}
+	if len(lp.Sink.Address) == 0 && len(lp.Sink.File) == 0 {
+		sr.Sink = "stdout"
+	}
+	lp.mu.RUnlock()
+	sr.EventsRead = atomic.LoadUint64(&ps.eventsRead)
+	sr.EventsFiltered = atomic.LoadUint64(&ps.eventsFiltered)
+	sr.EventsWritten = atomic.LoadUint64(&ps.eventsWritten)
+	sr.EventsDropped = atomic.LoadUint64(&ps.eventsDropped)
+	sr.EncodeErrors = atomic.LoadUint64(&ps.encodeErrors)
+	sr.SinkErrors = atomic.LoadUint64(&ps.sinkErrors)
+	sr.BytesWritten = atomic.LoadUint64(&ps.bytesWritten)
+	if last := atomic.LoadInt64(&ps.lastEvent); last != 0 {
+		t := time.Unix(0, last)
+		sr.LastEvent = &t
+	}
+	sr.Inbound = QueueStats{Len: len(lp.Inbound), Cap: cap(lp.Inbound)}
+	sr.Outbound = QueueStats{Len: len(lp.Outbound), Cap: cap(lp.Outbound)}
+	sr.LastWriteLatency = time.Duration(atomic.LoadInt64(&ps.lastWriteNanos)).String()
+	if writes := atomic.LoadUint64(&ps.writes); writes != 0 {
+		sr.writeLatency = time.Duration(atomic.LoadUint64(&ps.writeNanos) / writes)
+		sr.AvgBatchSize = float64(sr.EventsWritten) / float64(writes)
+	}
+	sr.AvgWriteLatency = sr.writeLatency.String()
+	sr.LastBatchSize = atomic.LoadInt64(&ps.lastBatchSize)
+	sr.Reconnects = atomic.LoadUint64(&ps.reconnects)
+	sr.QueueDrops = atomic.LoadUint64(&ps.queueDrops)
+	sr.EventsSpilled = atomic.LoadUint64(&ps.eventsSpilled)
+	if lp.inSpill != nil {
+		sr.SpillPending = lp.inSpill.Len() + lp.outSpill.Len()
+	}
+	if offset, ok := lp.skew.Offset(); ok {
+		sr.clockOffset = &offset
+		sr.ClockOffset = offset.String()
+	}
+	return sr
+}
+
+//Stats for one source, or every source in the collection if node is empty
+func handleStatsCollection(cmd *Command) error {
+	var results []StatsResult
+	if len(cmd.Node) != 0 {
+		lp := getProxy(cmd.Node)
+		if lp == nil {
+			return errors.New(fmt.Sprintf("ERROR - Source: %s not in collection", cmd.Node))
+		}
+		results = append(results, lp.StatsSnapshot())
+	} else {
+		for _, lp := range proxies() {
+			results = append(results, lp.StatsSnapshot())
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+	ent, err := json.MarshalIndent(results, "", "\t")
+	if err != nil {
+		return err
+	}
+	cmd.Response.Write(ent)
+	return nil
+}
+
+//Print stats as a table, one row per source
+func printStats(w io.Writer, results []StatsResult) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSTATE\tREAD\tFILTERED\tWRITTEN\tDROPPED\tENC ERR\tSINK ERR\tBYTES\tIN\tOUT\tLATENCY\tCLOCK\tLAST EVENT")
+	for _, sr := range results {
+		last := "never"
+		if sr.LastEvent != nil {
+			last = fmt.Sprintf("%s ago", time.Since(*sr.LastEvent).Truncate(time.Second))
+		}
+		clock := sr.ClockOffset
+		if len(clock) == 0 {
+			clock = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d/%d\t%d/%d\t%s\t%s\t%s\n",
+			sr.Name, sr.State, sr.EventsRead, sr.EventsFiltered, sr.EventsWritten,
+			sr.EventsDropped, sr.EncodeErrors, sr.SinkErrors, sr.BytesWritten,
+			sr.Inbound.Len, sr.Inbound.Cap, sr.Outbound.Len, sr.Outbound.Cap,
+			sr.AvgWriteLatency, clock, last)
+	}
+	tw.Flush()
+}
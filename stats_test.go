@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStatsCounters(t *testing.T) {
+	node := newTestNode(t)
+	path := filepath.Join(t.TempDir(), "events.json")
+	node.Source.Filters = []Filter{{Field: "requestId", Value: "2", Exclude: true}}
+	lp := startTestProxy(t, node.Source, Sink{Format: "json", File: path})
+	for i := 1; i <= 3; i++ {
+		node.events <- testNodeEvent(i)
+	}
+	read := eventsRead(lp)
+	waitFor(t, "events", func() bool { return read() == 3 })
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	removeProxy(lp)
+	lp.Shutdown(ctx)
+
+	sr := lp.StatsSnapshot()
+	if sr.EventsRead != 3 || sr.EventsFiltered != 1 || sr.EventsWritten != 2 {
+		t.Error(fmt.Sprintf("Invalid Event Counters: read %d filtered %d written %d", sr.EventsRead, sr.EventsFiltered, sr.EventsWritten))
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sr.BytesWritten != uint64(info.Size()) {
+		t.Error(fmt.Sprintf("Invalid Bytes Written: %d file: %d", sr.BytesWritten, info.Size()))
+	}
+	if sr.LastEvent == nil || sr.LastBatchSize != 1 || sr.AvgBatchSize != 1 {
+		t.Error(fmt.Sprintf("Invalid Batch Stats: %v %d %f", sr.LastEvent, sr.LastBatchSize, sr.AvgBatchSize))
+	}
+	if sr.EventsDropped != 0 || sr.SinkErrors != 0 || sr.Inbound.Cap != node.Source.bufferSize() {
+		t.Error(fmt.Sprintf("Invalid Stats: %+v", sr))
+	}
+}
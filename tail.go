@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+//Events a tail client wants, empty fields match everything
+type TailFilter struct {
+	Node   string
+	System string
+	Event  string
+	Tags   []Tag
+}
+
+func (tf TailFilter) Match(node string, le LogEvent) bool {
+	if len(tf.Node) != 0 && tf.Node != node {
+		return false
+	}
+	if len(tf.System) != 0 && le.System != tf.System {
+		return false
+	}
+	if len(tf.Event) != 0 && le.Event != tf.Event {
+		return false
+	}
+	for _, want := range tf.Tags {
+		if !le.hasTag(want) {
+			return false
+		}
+	}
+	return true
+}
+
+//Returns true if the event has the tag, or a message field with the same value
+func (le *LogEvent) hasTag(tag Tag) bool {
+	for _, t := range le.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	v, ok := le.Message[tag.Name]
+	return ok && fmt.Sprint(v) == tag.Value
+}
+
+//Copy of the event which can be encoded without racing the pipeline
+func (le LogEvent) copy() LogEvent {
+	msg := make(map[string]interface{}, len(le.Message))
+	for k, v := range le.Message {
+		msg[k] = v
+	}
+	le.Message = msg
+	le.Tags = append([]Tag(nil), le.Tags...)
+	return le
+}
+
+type tailSubscriber struct {
+	filter  TailFilter
+	events  chan LogEvent
+	dropped uint64
+}
+
+//Fans filtered events of every proxy out to tail clients
+type tailHub struct {
+	mu   sync.RWMutex
+	subs map[*tailSubscriber]bool
+}
+
+var tailEvents = &tailHub{subs: make(map[*tailSubscriber]bool)}
+
+func (h *tailHub) subscribe(filter TailFilter) *tailSubscriber {
+	sub := &tailSubscriber{
+		filter: filter,
+		events: make(chan LogEvent, 256),
+	}
+	h.mu.Lock()
+	h.subs[sub] = true
+	h.mu.Unlock()
+	return sub
+}
+
+func (h *tailHub) unsubscribe(sub *tailSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs[sub] {
+		delete(h.subs, sub)
+		close(sub.events)
+	}
+}
+
+//Disconnect every client, used on shutdown
+func (h *tailHub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		delete(h.subs, sub)
+		close(sub.events)
+	}
+}
+
+//Hand an event to every matching client, clients which can not keep up miss events
+func (h *tailHub) publish(node string, le LogEvent) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for sub := range h.subs {
+		if !sub.filter.Match(node, le) {
+			continue
+		}
+		select {
+		case sub.events <- le.copy():
+		default:
+			atomic.AddUint64(&sub.dropped, 1)
+		}
+	}
+}
+
+//Stream events to the client as they pass through the filters of any proxy
+func handleTail(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := TailFilter{
+		Node:   q.Get("node"),
+		System: q.Get("system"),
+		Event:  q.Get("event"),
+	}
+	tags, err := MakeTags(q["tag"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.Tags = tags
+	format := q.Get("format")
+	if !(format == "" || format == "json" || format == "lineprotocol") {
+		http.Error(w, fmt.Sprintf("Unknown format: %s", format), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	sub := tailEvents.subscribe(filter)
+	defer tailEvents.unsubscribe(sub)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case event, ok := <-sub.events:
+			if !ok {
+				return
+			}
+			var b []byte
+			if format == "lineprotocol" {
+				b, err = event.ToLP()
+			} else {
+				b, err = event.ToJSON()
+				b = append(b, '\n')
+			}
+			if err != nil {
+				continue
+			}
+			if _, err := w.Write(b); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+//Connect to the tail endpoint of ipfs-metricsd
+func OpenTail(filter TailFilter, format string) (io.ReadCloser, error) {
+	q := url.Values{}
+	if len(filter.Node) != 0 {
+		q.Set("node", filter.Node)
+	}
+	if len(filter.System) != 0 {
+		q.Set("system", filter.System)
+	}
+	if len(filter.Event) != 0 {
+		q.Set("event", filter.Event)
+	}
+	for _, t := range filter.Tags {
+		q.Add("tag", t.String())
+	}
+	if len(format) != 0 {
+		q.Set("format", format)
+	}
+	resp, err := api.Do("GET", "/tail?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		return nil, errors.New(fmt.Sprintf("Tail: %s %s", resp.Status, strings.TrimSpace(msg)))
+	}
+	return resp.Body, nil
+}
+
+const (
+	colorReset = "\033[0m"
+	colorRed   = "\033[31m"
+	colorDim   = "\033[2m"
+)
+
+var systemColors = []string{"\033[32m", "\033[33m", "\033[34m", "\033[35m", "\033[36m", "\033[92m", "\033[94m", "\033[96m"}
+
+//Returns true if the writer is a terminal, so colors can be used
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+//An event counts as an error if its name or fields say so
+func isErrorEvent(le LogEvent) bool {
+	if _, ok := le.Message["error"]; ok {
+		return true
+	}
+	return strings.Contains(strings.ToLower(le.Event), "error")
+}
+
+//Print an event on one line: time, node, system, event then the remaining fields
+func printPrettyEvent(w io.Writer, le LogEvent, color bool) {
+	ts := fmt.Sprint(le.Message["time"])
+	if !le.Time.IsZero() {
+		ts = le.Time.Local().Format("15:04:05.000")
+	}
+	node := ""
+	var rest []string
+	for _, t := range le.Tags {
+		if t.Name == "nodeId" {
+			node = t.Value
+			if len(node) > 8 {
+				node = node[len(node)-8:]
+			}
+			continue
+		}
+		rest = append(rest, t.String())
+	}
+	var keys []string
+	for k := range le.Message {
+		switch k {
+		case "time", "system", "event":
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		rest = append(rest, fmt.Sprintf("%s=%v", k, le.Message[k]))
+	}
+	system := le.System
+	event := le.Event
+	if color {
+		h := fnv.New32a()
+		h.Write([]byte(system))
+		system = systemColors[h.Sum32()%uint32(len(systemColors))] + system + colorReset
+		ts = colorDim + ts + colorReset
+		if isErrorEvent(le) {
+			event = colorRed + event + colorReset
+		}
+	}
+	fmt.Fprintf(w, "%s %-8s %s %s %s\n", ts, node, system, event, strings.Join(rest, " "))
+}
+
+//Print the tail stream, pretty prints json events unless raw output is wanted
+func printTail(w io.Writer, stream io.Reader, pretty, color bool) error {
+	if !pretty {
+		_, err := io.Copy(w, stream)
+		return err
+	}
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var le LogEvent
+		if err := json.Unmarshal(scanner.Bytes(), &le); err != nil {
+			errlog.Println("Tail decode: ", err)
+			continue
+		}
+		printPrettyEvent(w, le, color)
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+func TestTailFilterMatch(t *testing.T) {
+	le := NewLogEvent(map[string]interface{}{"system": "dht", "event": "findPeer", "peer": "QmB"})
+	le.AddTags([]Tag{MakeTag("env", "prod")})
+	tests := []struct {
+		filter TailFilter
+		match  bool
+	}{
+		{TailFilter{}, true},
+		{TailFilter{Node: "QmA", System: "dht", Event: "findPeer"}, true},
+		{TailFilter{Node: "QmC"}, false},
+		{TailFilter{System: "bitswap"}, false},
+		{TailFilter{Event: "dial"}, false},
+		{TailFilter{Tags: []Tag{MakeTag("env", "prod")}}, true},
+		{TailFilter{Tags: []Tag{MakeTag("peer", "QmB")}}, true},
+		{TailFilter{Tags: []Tag{MakeTag("env", "prod"), MakeTag("peer", "QmC")}}, false},
+	}
+	for _, test := range tests {
+		if test.filter.Match("QmA", le) != test.match {
+			t.Error(fmt.Sprintf("Invalid Match: %+v expected: %t", test.filter, test.match))
+		}
+	}
+}
+
+func TestTailDropped(t *testing.T) {
+	hub := &tailHub{subs: make(map[*tailSubscriber]bool)}
+	slow := hub.subscribe(TailFilter{})
+	other := hub.subscribe(TailFilter{System: "bitswap"})
+	le := NewLogEvent(map[string]interface{}{"system": "dht", "event": "findPeer"})
+	for i := 0; i < cap(slow.events)+5; i++ {
+		hub.publish("QmA", le)
+	}
+	if n := atomic.LoadUint64(&slow.dropped); n != 5 || len(slow.events) != cap(slow.events) {
+		t.Error(fmt.Sprintf("Invalid Dropped: %d queued: %d", n, len(slow.events)))
+	}
+	//events the client did not ask for are not counted as dropped
+	if n := atomic.LoadUint64(&other.dropped); n != 0 || len(other.events) != 0 {
+		t.Error(fmt.Sprintf("Invalid Dropped Unmatched: %d queued: %d", n, len(other.events)))
+	}
+	hub.unsubscribe(slow)
+	hub.unsubscribe(slow)
+	if len(hub.subs) != 1 {
+		t.Error(fmt.Sprintf("Invalid Subscribers: %d", len(hub.subs)))
+	}
+	hub.closeAll()
+	if _, ok := <-other.events; ok || len(hub.subs) != 0 {
+		t.Error("Subscriber not closed")
+	}
+}
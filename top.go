@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+const (
+	topWindow  = 10 //seconds of events the rates are computed over
+	topSamples = 512
+)
+
+//Live numbers of one system of one node
+type topRow struct {
+	Node      string
+	System    string
+	Total     uint64
+	Errors    uint64
+	buckets   [topWindow]uint64 //events per second, indexed by unix second
+	bucketSec [topWindow]int64
+	durations []float64 //ring of the most recent durations
+	next      int
+}
+
+func (r *topRow) add(le LogEvent, now time.Time) {
+	r.Total++
+	if isErrorEvent(le) {
+		r.Errors++
+	}
+	sec := now.Unix()
+	i := sec % topWindow
+	if r.bucketSec[i] != sec {
+		r.bucketSec[i] = sec
+		r.buckets[i] = 0
+	}
+	r.buckets[i]++
+	if le.Duration > 0 {
+		d := float64(le.Duration)
+		if len(r.durations) < topSamples {
+			r.durations = append(r.durations, d)
+		} else {
+			r.durations[r.next] = d
+			r.next = (r.next + 1) % topSamples
+		}
+	}
+}
+
+//Events per second over the window
+func (r *topRow) rate(now time.Time) float64 {
+	var n uint64
+	for i := range r.buckets {
+		if now.Unix()-r.bucketSec[i] < topWindow {
+			n += r.buckets[i]
+		}
+	}
+	return float64(n) / topWindow
+}
+
+//Returns the p50 and p99 of the recent durations
+func (r *topRow) percentiles() (time.Duration, time.Duration) {
+	if len(r.durations) == 0 {
+		return 0, 0
+	}
+	sorted := append([]float64(nil), r.durations...)
+	sort.Float64s(sorted)
+	at := func(p float64) time.Duration {
+		return time.Duration(sorted[int(p*float64(len(sorted)-1))])
+	}
+	return at(0.50), at(0.99)
+}
+
+var topSortKeys = []string{"rate", "p99", "errors", "name"}
+
+//State of the top command, events arrive from the tail stream
+//while the screen is redrawn and keys are read
+type topModel struct {
+	mu      sync.Mutex
+	rows    map[string]*topRow
+	proxies []StatsResult
+	sortBy  int
+	reverse bool
+	filter  string
+	editing bool //typing a filter
+	input   string
+	err     error
+}
+
+func (m *topModel) add(le LogEvent) {
+	node := ""
+	for _, t := range le.Tags {
+		if t.Name == "nodeId" {
+			node = t.Value
+		}
+	}
+	system := le.System
+	key := node + "/" + system
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	row := m.rows[key]
+	if row == nil {
+		row = &topRow{Node: node, System: system}
+		m.rows[key] = row
+	}
+	row.add(le, time.Now())
+}
+
+//Handle a key press, returns false to quit
+func (m *topModel) key(k byte) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.editing {
+		switch k {
+		case '\r', '\n':
+			m.filter = m.input
+			m.editing = false
+		case 27: //escape
+			m.editing = false
+		case 127, 8: //backspace
+			if len(m.input) != 0 {
+				m.input = m.input[:len(m.input)-1]
+			}
+		default:
+			if k >= 32 && k < 127 {
+				m.input += string(k)
+			}
+		}
+		return true
+	}
+	switch k {
+	case 'q', 3: //ctrl-c
+		return false
+	case 's':
+		m.sortBy = (m.sortBy + 1) % len(topSortKeys)
+	case 'r':
+		m.reverse = !m.reverse
+	case '/':
+		m.editing = true
+		m.input = m.filter
+	case 'c':
+		m.filter = ""
+	}
+	return true
+}
+
+func (m *topModel) render(w io.Writer, width, height int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	var b bytes.Buffer
+	line := func(format string, args ...interface{}) {
+		s := fmt.Sprintf(format, args...)
+		if width > 0 && len(s) > width {
+			s = s[:width]
+		}
+		b.WriteString(s + "\033[K\r\n")
+	}
+	b.WriteString("\033[H")
+	sortBy := topSortKeys[m.sortBy]
+	if m.reverse {
+		sortBy += " (reversed)"
+	}
+	line("ipfs-metrics top - %s  sort: %s  filter: %s", now.Format("15:04:05"), sortBy, m.filter)
+	if m.err != nil {
+		line("error: %v", m.err)
+	}
+	line("")
+	line("%-20s %-8s %10s %10s %8s %10s", "PROXY", "STATE", "READ", "DROPPED", "IN", "LAST EVENT")
+	for _, sr := range m.proxies {
+		last := "never"
+		if sr.LastEvent != nil {
+			last = fmt.Sprintf("%s ago", now.Sub(*sr.LastEvent).Truncate(time.Second))
+		}
+		line("%-20s %-8s %10d %10d %8s %10s", shortNode(sr.Name, 20), sr.State, sr.EventsRead, sr.EventsDropped, fmt.Sprintf("%d/%d", sr.Inbound.Len, sr.Inbound.Cap), last)
+	}
+	line("")
+	line("%-20s %-24s %10s %12s %12s %8s %10s", "NODE", "SYSTEM", "EVENTS/S", "P50", "P99", "ERRORS", "TOTAL")
+
+	type shown struct {
+		row      *topRow
+		rate     float64
+		p50, p99 time.Duration
+	}
+	var rows []shown
+	for _, r := range m.rows {
+		if len(m.filter) != 0 && !strings.Contains(r.Node+" "+r.System, m.filter) {
+			continue
+		}
+		p50, p99 := r.percentiles()
+		rows = append(rows, shown{r, r.rate(now), p50, p99})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if m.reverse {
+			a, b = b, a
+		}
+		switch topSortKeys[m.sortBy] {
+		case "rate":
+			return a.rate > b.rate
+		case "p99":
+			return a.p99 > b.p99
+		case "errors":
+			return a.row.Errors > b.row.Errors
+		}
+		return a.row.Node+a.row.System < b.row.Node+b.row.System
+	})
+	//leave room for the header, proxies and the help line
+	room := height - 7 - len(m.proxies)
+	for i, r := range rows {
+		if height > 0 && i >= room {
+			break
+		}
+		line("%-20s %-24s %10.1f %12s %12s %8d %10d", shortNode(r.row.Node, 20), r.row.System, r.rate, r.p50, r.p99, r.row.Errors, r.row.Total)
+	}
+	b.WriteString("\033[J")
+	if m.editing {
+		line("filter: %s", m.input)
+	} else {
+		line("q quit  s sort  r reverse  / filter  c clear filter")
+	}
+	w.Write(b.Bytes())
+}
+
+func shortNode(node string, n int) string {
+	if len(node) > n {
+		return node[:n-3] + "..."
+	}
+	return node
+}
+
+//Poll the stats of the proxies in collection
+func (m *topModel) pollStats(interval time.Duration) {
+	for {
+		var results []StatsResult
+		resp, err := SendCommand(&Command{Type: "stats"})
+		if err == nil {
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			err = json.Unmarshal(body, &results)
+		}
+		m.mu.Lock()
+		m.proxies = results
+		m.err = err
+		m.mu.Unlock()
+		time.Sleep(interval)
+	}
+}
+
+//Read the tail stream into the model
+func (m *topModel) readTail(stream io.Reader) {
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var le LogEvent
+		if err := json.Unmarshal(scanner.Bytes(), &le); err != nil {
+			continue
+		}
+		m.add(le)
+	}
+	m.mu.Lock()
+	m.err = errors.New(fmt.Sprintf("tail stream closed: %v", scanner.Err()))
+	m.mu.Unlock()
+}
+
+//Run the interactive dashboard until q is pressed
+func RunTop(filter TailFilter, refresh time.Duration) error {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return errors.New("top needs a terminal")
+	}
+	stream, err := OpenTail(filter, "json")
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	m := &topModel{rows: make(map[string]*topRow)}
+	go m.readTail(stream)
+	go m.pollStats(refresh)
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, state)
+	//switch to the alternate screen and hide the cursor
+	fmt.Print("\033[?1049h\033[?25l")
+	defer fmt.Print("\033[?25h\033[?1049l")
+
+	keys := make(chan byte)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := os.Stdin.Read(buf); err != nil {
+				close(keys)
+				return
+			}
+			keys <- buf[0]
+		}
+	}()
+
+	ticker := time.NewTicker(refresh)
+	defer ticker.Stop()
+	for {
+		width, height, _ := term.GetSize(int(os.Stdout.Fd()))
+		m.render(os.Stdout, width, height)
+		select {
+		case k, ok := <-keys:
+			if !ok || !m.key(k) {
+				return nil
+			}
+		case <-ticker.C:
+		}
+	}
+}
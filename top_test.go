@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTopRate(t *testing.T) {
+	r := &topRow{Node: "QmA", System: "dht"}
+	start := time.Unix(1510956550, 0)
+	for i := 0; i < 20; i++ {
+		r.add(LogEvent{Event: "findPeer"}, start)
+	}
+	for i := 0; i < 10; i++ {
+		r.add(LogEvent{Event: "findPeerError"}, start.Add(3*time.Second))
+	}
+	tests := []struct {
+		after time.Duration
+		rate  float64
+	}{
+		{3 * time.Second, 3},
+		{9 * time.Second, 3},
+		//the first second has left the window
+		{10 * time.Second, 1},
+		{13 * time.Second, 0},
+	}
+	for _, test := range tests {
+		if rate := r.rate(start.Add(test.after)); rate != test.rate {
+			t.Error(fmt.Sprintf("Invalid Rate After %s: %f expected: %f", test.after, rate, test.rate))
+		}
+	}
+	if r.Total != 30 || r.Errors != 10 {
+		t.Error(fmt.Sprintf("Invalid Totals: %d errors: %d", r.Total, r.Errors))
+	}
+
+	//a second a full window later reuses the bucket of the first
+	r.add(LogEvent{Event: "findPeer"}, start.Add(topWindow*time.Second))
+	if rate := r.rate(start.Add(topWindow * time.Second)); rate != 1.1 {
+		t.Error(fmt.Sprintf("Invalid Rate After Reuse: %f", rate))
+	}
+}
+
+func TestTopPercentiles(t *testing.T) {
+	r := &topRow{}
+	if p50, p99 := r.percentiles(); p50 != 0 || p99 != 0 {
+		t.Error(fmt.Sprintf("Invalid Empty Percentiles: %s %s", p50, p99))
+	}
+	now := time.Now()
+	for i := 100; i >= 1; i-- {
+		r.add(LogEvent{Duration: time.Duration(i) * time.Millisecond}, now)
+	}
+	//events without a duration are not sampled
+	r.add(LogEvent{}, now)
+	if p50, p99 := r.percentiles(); p50 != 50*time.Millisecond || p99 != 99*time.Millisecond {
+		t.Error(fmt.Sprintf("Invalid Percentiles: %s %s", p50, p99))
+	}
+
+	//only the most recent samples are kept
+	for i := 0; i < topSamples; i++ {
+		r.add(LogEvent{Duration: time.Second}, now)
+	}
+	if p50, p99 := r.percentiles(); len(r.durations) != topSamples || p50 != time.Second || p99 != time.Second {
+		t.Error(fmt.Sprintf("Invalid Recent Percentiles: %d %s %s", len(r.durations), p50, p99))
+	}
+}
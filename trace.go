@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//An event of the Chrome Trace Event Format, read by chrome://tracing and Perfetto
+//https://docs.google.com/document/d/1CvAClvFfyA5R-PhYUmn5OOQtYMH4h6I0nSsKchNAySU
+type TraceEvent struct {
+	Name string                 `json:"name"`
+	Cat  string                 `json:"cat,omitempty"`
+	Ph   string                 `json:"ph"`            //X a complete span, b and e the begin and end of an async span, M metadata
+	Ts   float64                `json:"ts"`            //microseconds
+	Dur  float64                `json:"dur,omitempty"` //microseconds
+	Pid  int                    `json:"pid"`
+	Tid  int                    `json:"tid"`
+	ID   string                 `json:"id,omitempty"` //pairs the begin and end of an async span
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+//Process name of events without a node
+const traceUnknownNode = "unknown node"
+
+//Message keys which are part of the span itself, the rest become its args
+var traceSpanKeys = map[string]bool{"system": true, "event": true, "time": true, "duration": true}
+
+//Turns events into trace spans, with one process per node and one thread per system,
+//or per system and session. Numbers are given to nodes and threads as they are first seen.
+type TraceEncoder struct {
+	mu      sync.Mutex
+	pids    map[string]int
+	threads map[string]int //tids by pid and thread name
+	lastPid int
+	lastTid int
+}
+
+func NewTraceEncoder() *TraceEncoder {
+	return &TraceEncoder{
+		pids:    make(map[string]int),
+		threads: make(map[string]int),
+	}
+}
+
+//Returns the trace events of an event, preceded by the metadata naming its
+//process and thread the first time they are seen. Events with a duration are
+//logged when they end and become complete spans, <operation>Begin and
+//<operation>End events become async spans. Other events have no span, so none.
+func (te *TraceEncoder) Encode(le LogEvent, node string) ([]TraceEvent, error) {
+	system := le.System
+	event := le.Event
+	span := TraceEvent{Name: event, Cat: system}
+	switch {
+	case le.Duration > 0:
+		span.Ph = "X"
+		span.Dur = float64(le.Duration) / 1000
+	case strings.HasSuffix(event, "Begin"):
+		span.Ph = "b"
+		span.Name = strings.TrimSuffix(event, "Begin")
+	case strings.HasSuffix(event, "End"):
+		span.Ph = "e"
+		span.Name = strings.TrimSuffix(event, "End")
+	default:
+		return nil, nil
+	}
+	if le.Time.IsZero() {
+		return nil, errors.New(fmt.Sprintf("Trace event: %s %s has no time", system, event))
+	}
+	span.Ts = traceMicros(le.Time)
+	if span.Ph == "X" {
+		span.Ts -= span.Dur
+	}
+	node = eventNode(le, node)
+	if span.Ph != "X" {
+		//async spans are paired by name and id, like analyze pairs them
+		id := []string{node}
+		for _, key := range pairKeys {
+			id = append(id, messageString(le, key))
+		}
+		span.ID = strings.Join(id, "/")
+	}
+	for key, value := range le.Message {
+		if traceSpanKeys[key] {
+			continue
+		}
+		if span.Args == nil {
+			span.Args = make(map[string]interface{})
+		}
+		span.Args[key] = value
+	}
+	for _, tag := range le.Tags {
+		if tag.Name == "nodeId" {
+			continue
+		}
+		if span.Args == nil {
+			span.Args = make(map[string]interface{})
+		}
+		span.Args[tag.Name] = tag.Value
+	}
+
+	thread := system
+	if session := messageString(le, "session"); len(session) != 0 {
+		thread = system + "/" + session
+	}
+	te.mu.Lock()
+	defer te.mu.Unlock()
+	var events []TraceEvent
+	pid, ok := te.pids[node]
+	if !ok {
+		te.lastPid++
+		pid = te.lastPid
+		te.pids[node] = pid
+		name := node
+		if len(name) == 0 {
+			name = traceUnknownNode
+		}
+		events = append(events, TraceEvent{Name: "process_name", Ph: "M", Pid: pid, Args: map[string]interface{}{"name": name}})
+	}
+	key := fmt.Sprintf("%d/%s", pid, thread)
+	tid, ok := te.threads[key]
+	if !ok {
+		te.lastTid++
+		tid = te.lastTid
+		te.threads[key] = tid
+		events = append(events, TraceEvent{Name: "thread_name", Ph: "M", Pid: pid, Tid: tid, Args: map[string]interface{}{"name": thread}})
+	}
+	span.Pid = pid
+	span.Tid = tid
+	return append(events, span), nil
+}
+
+//Continue the numbering of a trace file which is appended to, from the metadata
+//naming its processes and threads, so the nodes keep their processes
+func (te *TraceEncoder) load(r io.Reader) error {
+	te.mu.Lock()
+	defer te.mu.Unlock()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), replayMaxLine)
+	for scanner.Scan() {
+		line := bytes.TrimSuffix(bytes.TrimSpace(scanner.Bytes()), []byte(","))
+		var event TraceEvent
+		if len(line) == 0 || line[0] != '{' || json.Unmarshal(line, &event) != nil || event.Ph != "M" {
+			continue
+		}
+		name, _ := event.Args["name"].(string)
+		switch event.Name {
+		case "process_name":
+			if name == traceUnknownNode {
+				name = ""
+			}
+			te.pids[name] = event.Pid
+		case "thread_name":
+			te.threads[fmt.Sprintf("%d/%s", event.Pid, name)] = event.Tid
+		}
+		if event.Pid > te.lastPid {
+			te.lastPid = event.Pid
+		}
+		if event.Tid > te.lastTid {
+			te.lastTid = event.Tid
+		}
+	}
+	return scanner.Err()
+}
+
+func (te *TraceEncoder) loadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return te.load(file)
+}
+
+func traceMicros(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1000
+}
+
+//Encode an event as lines of a trace json array, empty if it has no span.
+//The array is never closed, which both chrome://tracing and Perfetto accept.
+func (te *TraceEncoder) EncodeLines(le LogEvent, node string) ([]byte, error) {
+	events, err := te.Encode(le, node)
+	if err != nil {
+		return nil, err
+	}
+	var b []byte
+	for _, event := range events {
+		line, err := json.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, line...)
+		b = append(b, ",\n"...)
+	}
+	return b, nil
+}
+
+//Encode an event for the trace file of the sink
+func encodeTrace(sink Sink, event LogEvent, node string) ([]byte, error) {
+	sf, err := openSinkFile(sink)
+	if err != nil {
+		return nil, err
+	}
+	return sf.trace.EncodeLines(event, node)
+}
+
+//Convert archives or jsonl event logs into one trace, no daemon needed
+func WriteTrace(w io.Writer, paths []string, filters []Filter) (int, error) {
+	te := NewTraceEncoder()
+	out := bufio.NewWriter(w)
+	spans := 0
+	first := true
+	out.WriteString("[\n")
+	for _, path := range paths {
+		rr, err := OpenReplay(path)
+		if err != nil {
+			return spans, err
+		}
+		node := ""
+		if rr.Header != nil {
+			node = rr.Header.NodeId
+		}
+		for {
+			ev, err := rr.Next()
+			if err == io.EOF {
+				break
+			}
+			var lineErr *ReplayLineError
+			if errors.As(err, &lineErr) {
+				continue
+			}
+			if err != nil {
+				rr.Close()
+				return spans, err
+			}
+			if !PassFilters(filters, ev.Event) {
+				continue
+			}
+			events, err := te.Encode(ev.Event, node)
+			if err != nil || len(events) == 0 {
+				continue
+			}
+			spans++
+			for _, event := range events {
+				b, err := json.Marshal(event)
+				if err != nil {
+					rr.Close()
+					return spans, err
+				}
+				if !first {
+					out.WriteString(",\n")
+				}
+				first = false
+				out.Write(b)
+			}
+		}
+		rr.Close()
+	}
+	out.WriteString("\n]\n")
+	return spans, out.Flush()
+}
+
+//Write the trace of the files to the output, or stdout
+func traceFiles(paths []string, output string, filters []Filter) error {
+	w := os.Stdout
+	if len(output) != 0 {
+		file, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	spans, err := WriteTrace(w, paths, filters)
+	if err != nil {
+		return err
+	}
+	if len(output) != 0 {
+		infolog.Printf("Wrote %d spans to: %s\n", spans, output)
+	}
+	return nil
+}
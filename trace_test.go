@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestTraceEncode(t *testing.T) {
+	te := NewTraceEncoder()
+	dial := NewLogEvent(map[string]interface{}{"system": "swarm2", "event": "swarmDialAttemptSync", "time": "2017-11-17T22:09:11Z", "duration": float64(2000000), "peerID": "QmA"})
+	dial.Tags = []Tag{MakeTag("nodeId", "QmNode"), MakeTag("dc", "ams")}
+	events, err := te.Encode(dial, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the process and thread are named the first time they are seen
+	if len(events) != 3 || events[0].Args["name"] != "QmNode" || events[1].Args["name"] != "swarm2" {
+		t.Fatal(fmt.Sprintf("Invalid Metadata: %v", events))
+	}
+	span := events[2]
+	if span.Ph != "X" || span.Dur != 2000 || span.Ts != 1510956551000000-2000 || span.Args["peerID"] != "QmA" || span.Args["dc"] != "ams" {
+		t.Error(fmt.Sprintf("Invalid Span: %v", span))
+	}
+
+	begin := NewLogEvent(map[string]interface{}{"system": "dht", "event": "findPeerSingleBegin", "time": "2017-11-17T22:09:10Z", "session": "s1", "requestId": float64(7)})
+	events, err = te.Encode(begin, "QmNode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//a new thread of the same process
+	if len(events) != 2 || events[0].Args["name"] != "dht/s1" || events[1].Pid != span.Pid || events[1].Tid == span.Tid {
+		t.Fatal(fmt.Sprintf("Invalid Thread: %v", events))
+	}
+	if events[1].Ph != "b" || events[1].Name != "findPeerSingle" || events[1].ID != "QmNode/7/s1/" {
+		t.Error(fmt.Sprintf("Invalid Async Span: %v", events[1]))
+	}
+
+	plain := NewLogEvent(map[string]interface{}{"system": "dht", "event": "handleAddProvider"})
+	if events, err := te.Encode(plain, "QmNode"); err != nil || len(events) != 0 {
+		t.Error(fmt.Sprintf("Event without a span: %v %v", events, err))
+	}
+}
+
+func TestWriteTrace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	if err := ioutil.WriteFile(path, []byte(analyzeLog), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	spans, err := WriteTrace(&out, []string{path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []TraceEvent
+	if err := json.Unmarshal(out.Bytes(), &events); err != nil {
+		t.Fatal(err)
+	}
+	//4 begin and end events, 3 dials, named by 1 process and 2 threads
+	if spans != 7 || len(events) != 10 {
+		t.Error(fmt.Sprintf("Invalid Trace: %d spans %d events", spans, len(events)))
+	}
+}
+
+func TestTraceSinkFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.json")
+	defer closeSinkFiles()
+	sink := Sink{Format: "trace", File: path}
+	dial := NewLogEvent(map[string]interface{}{"system": "swarm2", "event": "dial", "time": "2017-11-17T22:09:11Z", "duration": float64(2000000)})
+	b, err := encodeTrace(sink, dial, "QmA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf, _ := openSinkFile(sink)
+	if err := sf.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	//a sink of another format can not write to the same file
+	if _, err := openSinkFile(Sink{Format: "json", File: path}); err == nil {
+		t.Error("Opened a trace file as json")
+	}
+	if ValidConfig(&Config{Sink: Sink{Format: "json", File: path}, Correlation: &Correlation{}}) == nil {
+		t.Error("Valid json sink on a trace file")
+	}
+
+	//appending to the file later keeps the numbers of its nodes and threads
+	closeSinkFiles()
+	te := NewTraceEncoder()
+	if err := te.loadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	events, err := te.Encode(dial, "QmA")
+	if err != nil || len(events) != 1 || events[0].Pid != 1 || events[0].Tid != 1 {
+		t.Error(fmt.Sprintf("Invalid Known Node: %v %v", events, err))
+	}
+	events, err = te.Encode(dial, "QmB")
+	if err != nil || len(events) != 3 || events[0].Pid != 2 || events[1].Tid != 2 {
+		t.Error(fmt.Sprintf("Invalid New Node: %v %v", events, err))
+	}
+}
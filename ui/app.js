@@ -0,0 +1,262 @@
+'use strict';
+
+// The ui talks to ipfs-metricsd through the same control api as the cli:
+// commands are PUT to / as json and events are streamed from /tail.
+
+const $ = (id) => document.getElementById(id);
+let token = localStorage.getItem('ipfs-metrics-token') || '';
+let editing = null; // node whose tags are being edited, not redrawn while typing
+let tail = null;
+
+function headers() {
+	return token ? {'Authorization': 'Bearer ' + token} : {};
+}
+
+function showError(msg) {
+	$('error').textContent = msg;
+	$('error').hidden = !msg;
+}
+
+async function send(cmd) {
+	const resp = await fetch('/', {method: 'PUT', headers: headers(), body: JSON.stringify(cmd)});
+	if (resp.status === 401) {
+		throw new Error('ipfs-metricsd rejected the api token');
+	}
+	return resp.text();
+}
+
+// Results of add, remove, pause, resume and update, errors of add are written before the result
+async function run(cmd) {
+	try {
+		const text = await send(cmd);
+		const i = text.indexOf('{');
+		const prefix = (i < 0 ? text : text.slice(0, i)).trim();
+		const result = i < 0 ? {} : JSON.parse(text.slice(i));
+		showError(prefix || (result.result === 'Success' ? '' : result.result));
+	} catch (e) {
+		showError(e.message);
+	}
+	refresh();
+}
+
+function parseTags(text) {
+	const tags = [];
+	for (const t of text.split(/\s+/).filter(Boolean)) {
+		const i = t.indexOf('=');
+		if (i < 1 || i === t.length - 1) {
+			throw new Error('Invalid tag: ' + t + ', expected tag=value');
+		}
+		tags.push({Name: t.slice(0, i), Value: t.slice(i + 1)});
+	}
+	return tags;
+}
+
+function formatTags(tags) {
+	return (tags || []).filter((t) => t.Name !== 'nodeId').map((t) => t.Name + '=' + t.Value).join(' ');
+}
+
+function cell(row, text, cls) {
+	const td = row.insertCell();
+	td.textContent = text;
+	if (cls) {
+		td.className = cls;
+	}
+	return td;
+}
+
+function button(parent, label, onclick) {
+	const b = document.createElement('button');
+	b.textContent = label;
+	b.onclick = onclick;
+	parent.appendChild(b);
+	return b;
+}
+
+function ago(time) {
+	if (!time) {
+		return 'never';
+	}
+	return Math.round((Date.now() - new Date(time)) / 1000) + 's ago';
+}
+
+async function refresh() {
+	if (editing) {
+		return;
+	}
+	let stats;
+	try {
+		stats = JSON.parse(await send({type: 'stats'})) || [];
+	} catch (e) {
+		showError(e.message);
+		return;
+	}
+	const body = $('proxies');
+	body.textContent = '';
+	for (const s of stats) {
+		const row = body.insertRow();
+		cell(row, s.name);
+		cell(row, s.state, s.state === 'paused' ? 'paused' : '');
+		cell(row, s.source);
+		cell(row, s.sink);
+		cell(row, s.eventsRead);
+		cell(row, s.eventsWritten);
+		cell(row, s.eventsDropped, s.eventsDropped ? 'error' : '');
+		cell(row, s.inbound.len + '/' + s.inbound.cap);
+		cell(row, s.lastWriteLatency);
+		cell(row, ago(s.lastEvent));
+
+		const tagCell = cell(row, '', 'tags');
+		const input = document.createElement('input');
+		input.value = formatTags(s.tags);
+		input.onfocus = () => { editing = s.name; };
+		input.onblur = () => { editing = null; };
+		tagCell.appendChild(input);
+		button(tagCell, 'Save', () => {
+			editing = null;
+			try {
+				run({type: 'update', node: s.name, tags: parseTags(input.value)});
+			} catch (e) {
+				showError(e.message);
+			}
+		}).onmousedown = (e) => e.preventDefault();
+
+		const actions = row.insertCell();
+		if (s.state === 'paused') {
+			button(actions, 'Resume', () => run({type: 'resume', node: s.name}));
+		} else {
+			button(actions, 'Pause', () => run({type: 'pause', node: s.name}));
+		}
+		button(actions, 'Remove', () => {
+			if (confirm('Remove ' + s.name + ' from collection?')) {
+				run({type: 'remove', node: s.name});
+			}
+		});
+	}
+}
+
+function parseAddress(text, what) {
+	if (text.startsWith('/')) {
+		return {Address: text, Port: ''};
+	}
+	const i = text.lastIndexOf(':');
+	if (i < 1 || i === text.length - 1) {
+		throw new Error(what + ' format invalid, expected host:port');
+	}
+	return {Address: text.slice(0, i).replace(/^\[|\]$/g, ''), Port: text.slice(i + 1)};
+}
+
+$('add-form').onsubmit = (e) => {
+	e.preventDefault();
+	const f = e.target.elements;
+	try {
+		const source = parseAddress(f.input.value.trim(), 'Input');
+		source.Tags = parseTags(f.tags.value);
+		let sink = {Address: '', Port: ''};
+		if (f.output.value.trim()) {
+			sink = parseAddress(f.output.value.trim(), 'Output');
+		}
+		sink.Format = f.format.value;
+		run({type: 'add', source: [source], sink: sink});
+		e.target.reset();
+	} catch (err) {
+		showError(err.message);
+	}
+};
+
+$('token-form').onsubmit = (e) => {
+	e.preventDefault();
+	token = $('token').value;
+	localStorage.setItem('ipfs-metrics-token', token);
+	refresh();
+};
+
+// Print an event on one line like `ipfs-metrics tail`
+function printEvent(le) {
+	const msg = le.Message || {};
+	let node = '';
+	const rest = [];
+	for (const t of le.Tags || []) {
+		if (t.Name === 'nodeId') {
+			node = t.Value.slice(-8);
+		} else {
+			rest.push(t.Name + '=' + t.Value);
+		}
+	}
+	for (const k of Object.keys(msg).sort()) {
+		if (k !== 'time' && k !== 'system' && k !== 'event') {
+			rest.push(k + '=' + (typeof msg[k] === 'object' ? JSON.stringify(msg[k]) : msg[k]));
+		}
+	}
+	const time = msg.time ? new Date(msg.time).toLocaleTimeString() : '';
+	const line = document.createElement('div');
+	line.textContent = [time, node, msg.system, msg.event, rest.join(' ')].join(' ');
+	if ('error' in msg || String(msg.event).toLowerCase().includes('error')) {
+		line.className = 'error';
+	}
+	const events = $('events');
+	const atBottom = events.scrollTop + events.clientHeight >= events.scrollHeight - 4;
+	events.appendChild(line);
+	while (events.childNodes.length > 1000) {
+		events.removeChild(events.firstChild);
+	}
+	if (atBottom) {
+		events.scrollTop = events.scrollHeight;
+	}
+}
+
+async function startTail(f) {
+	tail = new AbortController();
+	$('tail-button').textContent = 'Stop';
+	try {
+		const q = new URLSearchParams();
+		for (const name of ['node', 'system', 'event']) {
+			if (f[name].value.trim()) {
+				q.set(name, f[name].value.trim());
+			}
+		}
+		for (const t of parseTags(f.tags.value)) {
+			q.append('tag', t.Name + '=' + t.Value);
+		}
+		const resp = await fetch('/tail?' + q, {headers: headers(), signal: tail.signal});
+		if (!resp.ok) {
+			throw new Error('Tail: ' + resp.status + ' ' + (await resp.text()).trim());
+		}
+		const reader = resp.body.getReader();
+		const decoder = new TextDecoder();
+		let buf = '';
+		for (;;) {
+			const {value, done} = await reader.read();
+			if (done) {
+				break;
+			}
+			buf += decoder.decode(value, {stream: true});
+			const lines = buf.split('\n');
+			buf = lines.pop();
+			for (const l of lines) {
+				if (l) {
+					printEvent(JSON.parse(l));
+				}
+			}
+		}
+	} catch (e) {
+		if (e.name !== 'AbortError') {
+			showError(e.message);
+		}
+	}
+	tail = null;
+	$('tail-button').textContent = 'Start';
+}
+
+$('tail-form').onsubmit = (e) => {
+	e.preventDefault();
+	if (tail) {
+		tail.abort();
+		return;
+	}
+	$('events').textContent = '';
+	startTail(e.target.elements);
+};
+
+$('token').value = token;
+refresh();
+setInterval(refresh, 2000);
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -19,14 +20,113 @@ func NewAddCommand(c *cli.Context) (*Command, error) {
 	config := new(Config)
 	//we will load file config, or use
 	if len(c.String("config")) != 0 {
-		config, err = LoadConfigFromFile(c.String("config"))
+		config, err = ValidateConfigFile(c.String("config"))
+		if err != nil {
+			return nil, err
+		}
 	} else {
 		config, err = LoadConfigFromArgs(c)
+		if err != nil {
+			return nil, err
+		}
+		//ensure we are pased valid config options
+		if err := ValidConfig(config); err != nil {
+			return nil, err
+		}
 	}
+	return &Command{
+		Type:        "add",
+		Source:      config.Source,
+		Sink:        config.Sink,
+		Discovery:   config.Discovery,
+		Correlation: config.Correlation,
+	}, nil
+}
+
+//returns a discover command or errors if invalid options given
+func NewDiscoverCommand(c *cli.Context) (*Command, error) {
+	cmd := &Command{Type: "discover"}
+	if c.Bool("stop") {
+		return cmd, nil
+	}
+	tags, err := MakeTags(c.Args())
+	if err != nil {
+		return nil, err
+	}
+	filters, err := MakeFilters(c.StringSlice("filter"))
+	if err != nil {
+		return nil, err
+	}
+	sink, err := SinkFromArgs(c)
 	if err != nil {
 		return nil, err
 	}
-	//ensure we are pased valid config options
+	config := &Config{
+		Sink: *sink,
+		Discovery: &Discovery{
+			Seed:     c.String("seed"),
+			APIPort:  c.String("api-port"),
+			Static:   c.StringSlice("static"),
+			SRV:      c.String("srv"),
+			Repos:    c.String("repos"),
+			Interval: c.Duration("interval").String(),
+			MaxNodes: c.Int("max-nodes"),
+			Source: Source{
+				Tags:         tags,
+				Filters:      filters,
+				BufferSize:   c.Int("buffer-size"),
+				Backpressure: c.String("backpressure"),
+				SpillDir:     c.String("spill-dir"),
+				PollInterval: pollIntervalFlag(c),
+			},
+		},
+	}
+	if err := ValidConfig(config); err != nil {
+		return nil, err
+	}
+	cmd.Sink = config.Sink
+	cmd.Discovery = config.Discovery
+	return cmd, nil
+}
+
+//returns an add command replaying a file, or errors if invalid options given
+func NewReplayCommand(c *cli.Context) (*Command, error) {
+	file := c.Args().First()
+	if len(file) == 0 {
+		return nil, errors.New("File to replay required")
+	}
+	//the daemon opens the file from its own working directory
+	path, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := MakeTags(c.Args().Tail())
+	if err != nil {
+		return nil, err
+	}
+	filters, err := MakeFilters(c.StringSlice("filter"))
+	if err != nil {
+		return nil, err
+	}
+	sink, err := SinkFromArgs(c)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{
+		Source: []Source{{
+			Type:         SourceReplay,
+			File:         path,
+			Speed:        c.String("speed"),
+			RewriteTime:  c.Bool("rewrite-time"),
+			LogFormat:    c.String("log-format"),
+			Tags:         tags,
+			Filters:      filters,
+			BufferSize:   c.Int("buffer-size"),
+			Backpressure: c.String("backpressure"),
+			SpillDir:     c.String("spill-dir"),
+		}},
+		Sink: *sink,
+	}
 	if err := ValidConfig(config); err != nil {
 		return nil, err
 	}
@@ -37,6 +137,89 @@ func NewAddCommand(c *cli.Context) (*Command, error) {
 	}, nil
 }
 
+//returns a correlate command or errors if invalid options given
+func NewCorrelateCommand(c *cli.Context) (*Command, error) {
+	cmd := &Command{Type: "correlate"}
+	if c.Bool("stop") {
+		return cmd, nil
+	}
+	sink, err := SinkFromArgs(c)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{
+		Sink: *sink,
+		Correlation: &Correlation{
+			Window:   c.Duration("window").String(),
+			Keys:     c.StringSlice("key"),
+			MinNodes: c.Int("min-nodes"),
+		},
+	}
+	if err := ValidConfig(config); err != nil {
+		return nil, err
+	}
+	cmd.Sink = config.Sink
+	cmd.Correlation = config.Correlation
+	return cmd, nil
+}
+
+//returns an update command or errors if invalid options given
+func NewUpdateCommand(c *cli.Context) (*Command, error) {
+	node := c.Args().First()
+	if len(node) == 0 {
+		return nil, errors.New("Node to update required")
+	}
+	cmd := &Command{
+		Type: "update",
+		Node: node,
+	}
+
+	tags, err := MakeTags(c.Args().Tail())
+	if err != nil {
+		return nil, err
+	}
+	if len(tags) != 0 {
+		cmd.Tags = tags
+	} else if c.Bool("clear-tags") {
+		cmd.Tags = []Tag{}
+	}
+
+	filters, err := MakeFilters(c.StringSlice("filter"))
+	if err != nil {
+		return nil, err
+	}
+	if len(filters) != 0 {
+		cmd.Filters = filters
+	} else if c.Bool("clear-filters") {
+		cmd.Filters = []Filter{}
+	}
+
+	//the sink is only replaced when an output or format is given
+	if len(c.String("output")) == 0 && len(c.String("format")) == 0 && !c.Bool("stdout") {
+		return cmd, nil
+	}
+	format := strings.ToLower(c.String("format"))
+	if len(format) == 0 {
+		return nil, errors.New("Format required when changing the output")
+	}
+	if !(format == "json" || format == "lineprotocol") {
+		return nil, errors.New(fmt.Sprintf("Unknown format: %s", format))
+	}
+	cmd.Sink = Sink{
+		Format:    format,
+		BatchSize: c.Int("batch-size"),
+	}
+	if len(c.String("output")) != 0 {
+		address, port, err := ParseOutput(c.String("output"))
+		if err != nil {
+			return nil, err
+		}
+		cmd.Sink.Address = address
+		cmd.Sink.Port = port
+	}
+	return cmd, nil
+}
+
 func CreateDatabase(dbName string, sink Sink) (*http.Response, error) {
 	influxUrl := fmt.Sprintf("http://%s", sink)
 	resource := "/query"
@@ -54,6 +237,7 @@ func CreateDatabase(dbName string, sink Sink) (*http.Response, error) {
 	}
 	r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	r.Header.Add("Content-Length", strconv.Itoa(len(data.Encode())))
+	sink.setAuth(r)
 
 	resp, err := client.Do(r)
 	if err != nil {
@@ -62,6 +246,14 @@ func CreateDatabase(dbName string, sink Sink) (*http.Response, error) {
 	return resp, nil
 }
 
+//Exit with why a command could not be sent to ipfs-metricsd
+func exitSendError(err error) {
+	if err == errUnauthorized {
+		errlog.Fatal(err)
+	}
+	errlog.Fatal("Please run `ipfs-metrics start` first")
+}
+
 func SendCommand(c *Command) (*http.Response, error) {
 	b, err := json.Marshal(c)
 	if err != nil {
@@ -69,41 +261,12 @@ func SendCommand(c *Command) (*http.Response, error) {
 		return nil, err
 	}
 
-	url := fmt.Sprintf("http://localhost%s", port)
-	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(b))
-	if err != nil {
-		errlog.Println("Failed to Create Request: ", err)
-		return nil, err
-	}
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := api.Do("PUT", "/", bytes.NewBuffer(b))
 	if err != nil {
-		errlog.Println(err)
+		if err != errUnauthorized {
+			errlog.Println(err)
+		}
 		return nil, err
 	}
 	return resp, nil
 }
-
-func GetIpfsLogAddress(source Source) string {
-	return fmt.Sprintf("http://%s/api/v0/log/tail?encoding=json&stream-channels=true", source)
-}
-
-func GetNodeId(source Source) (string, error) {
-	url := fmt.Sprintf("http://%s/api/v0/id", source)
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", err
-	}
-	var nodeInfo map[string]interface{}
-	err = json.NewDecoder(resp.Body).Decode(&nodeInfo)
-	if err != nil {
-		errlog.Println(err)
-		return "", err
-	}
-	nodeId := nodeInfo["ID"].(string)
-	if nodeId == "" {
-		return "", errors.New("Could not get nodeId, are you sure this is an ipfs daemon?")
-	}
-	return nodeId, nil
-}
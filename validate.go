@@ -0,0 +1,376 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//A problem with one field of a config
+type ConfigError struct {
+	Path    string //e.g. Source[2].Port
+	Message string
+}
+
+func (e ConfigError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+//Every problem found in a config
+type ConfigErrors []ConfigError
+
+func (errs ConfigErrors) Error() string {
+	lines := []string{"invalid config:"}
+	for _, e := range errs {
+		lines = append(lines, "  "+e.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (errs *ConfigErrors) add(path, format string, args ...interface{}) {
+	*errs = append(*errs, ConfigError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+//Returns nil, or the errors as an error
+func (errs ConfigErrors) err() error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+//Return nil if valid, else ConfigErrors with every problem
+func ValidConfig(config *Config) error {
+	var errs ConfigErrors
+	if len(config.Source) == 0 && config.Discovery == nil && config.Correlation == nil {
+		errs.add("Source", "no source specified")
+	}
+	seen := make(map[string]int)
+	for i, source := range config.Source {
+		path := fmt.Sprintf("Source[%d]", i)
+		validSource(source, path, &errs)
+		key := strings.ToLower(source.String())
+		if first, ok := seen[key]; ok {
+			errs.add(path, "duplicate of Source[%d]: %s", first, source)
+		} else {
+			seen[key] = i
+		}
+	}
+	if config.Discovery != nil {
+		validDiscovery(*config.Discovery, &errs)
+	}
+	if config.Correlation != nil {
+		validCorrelation(*config.Correlation, &errs)
+	}
+	validSink(config.Sink, &errs)
+	return errs.err()
+}
+
+func validDiscovery(d Discovery, errs *ConfigErrors) {
+	if len(d.Seed) == 0 && len(d.Static) == 0 && len(d.SRV) == 0 && len(d.Repos) == 0 {
+		errs.add("Discovery", "no Seed, Static, SRV or Repos to discover sources from")
+	}
+	if len(d.Seed) != 0 {
+		if _, _, err := ParseInput(d.Seed); err != nil {
+			errs.add("Discovery.Seed", "invalid address: %s", d.Seed)
+		}
+	}
+	if len(d.APIPort) != 0 && !validPort(d.APIPort) {
+		errs.add("Discovery.APIPort", "invalid port: %s, expected 1-65535", d.APIPort)
+	}
+	for i, addr := range d.Static {
+		if _, _, err := ParseInput(addr); err != nil {
+			errs.add(fmt.Sprintf("Discovery.Static[%d]", i), "invalid address: %s", addr)
+		}
+	}
+	if len(d.Repos) != 0 {
+		if _, err := filepath.Match(d.Repos, ""); err != nil {
+			errs.add("Discovery.Repos", "invalid glob: %v", err)
+		}
+	}
+	if len(d.Interval) != 0 {
+		if interval, err := time.ParseDuration(d.Interval); err != nil || interval <= 0 {
+			errs.add("Discovery.Interval", "invalid duration: %s", d.Interval)
+		}
+	}
+	if d.MaxNodes < 0 {
+		errs.add("Discovery.MaxNodes", "must not be negative")
+	}
+	if d.Source.isReplay() {
+		errs.add("Discovery.Source.Type", "a %s source is not discovered", SourceReplay)
+	}
+	if len(d.Source.Address) != 0 || len(d.Source.Port) != 0 {
+		errs.add("Discovery.Source", "the address of discovered sources is set by discovery")
+	}
+	validSourceOptions(d.Source, "Discovery.Source", errs)
+}
+
+func validCorrelation(c Correlation, errs *ConfigErrors) {
+	if len(c.Window) != 0 {
+		if window, err := time.ParseDuration(c.Window); err != nil {
+			errs.add("Correlation.Window", "invalid duration: %s", c.Window)
+		} else if window < minCorrelationWindow {
+			errs.add("Correlation.Window", "must be at least %s", minCorrelationWindow)
+		}
+	}
+	for i, key := range c.Keys {
+		if len(key) == 0 {
+			errs.add(fmt.Sprintf("Correlation.Keys[%d]", i), "must not be empty")
+		}
+	}
+	if c.MinNodes < 0 {
+		errs.add("Correlation.MinNodes", "must not be negative")
+	}
+}
+
+func validSource(source Source, path string, errs *ConfigErrors) {
+	if source.isReplay() {
+		if len(source.File) == 0 {
+			errs.add(path+".File", "required")
+		}
+		if len(source.Address) != 0 || len(source.Port) != 0 {
+			errs.add(path+".Address", "a %s source reads a file, not an address", SourceReplay)
+		}
+	} else if isMultiaddr(source.Address) {
+		if _, _, err := ParseMultiaddr(source.Address); err != nil {
+			errs.add(path+".Address", "%v", err)
+		}
+		if len(source.Port) != 0 {
+			errs.add(path+".Port", "must be empty when the address is a multiaddr")
+		}
+	} else {
+		validHostPort(source.Address, source.Port, path, errs)
+	}
+	validSourceOptions(source, path, errs)
+}
+
+//Validate what a source is collected with, apart from its address
+func validSourceOptions(source Source, path string, errs *ConfigErrors) {
+	for j, tag := range source.Tags {
+		if !ValidTag(tag.String()) {
+			errs.add(fmt.Sprintf("%s.Tags[%d]", path, j), "invalid tag: %s", tag)
+		}
+	}
+	for j, filter := range source.Filters {
+		if len(filter.Field) == 0 {
+			errs.add(fmt.Sprintf("%s.Filters[%d].Field", path, j), "required")
+		}
+	}
+	if source.BufferSize < 0 {
+		errs.add(path+".BufferSize", "must not be negative")
+	}
+	if !validBackpressure(source.Backpressure) {
+		errs.add(path+".Backpressure", "unknown policy: %s, expected one of %s", source.Backpressure, strings.Join(configEnums["Source.Backpressure"], ", "))
+	}
+	if !validSourceType(source.Type) {
+		errs.add(path+".Type", "unknown type: %s, expected one of %s", source.Type, strings.Join(configEnums["Source.Type"], ", "))
+	}
+	if !validClockSkew(source.ClockSkew) {
+		errs.add(path+".ClockSkew", "unknown mode: %s, expected one of %s", source.ClockSkew, strings.Join(configEnums["Source.ClockSkew"], ", "))
+	} else if source.ClockSkew != "" && source.ClockSkew != ClockSkewEstimate && (source.isPrometheus() || source.isReplay()) {
+		errs.add(path+".ClockSkew", "only the clock of an %s source is estimated", SourceEventLog)
+	}
+	if !validLogFormat(source.LogFormat) {
+		errs.add(path+".LogFormat", "unknown format: %s, expected one of %s", source.LogFormat, strings.Join(configEnums["Source.LogFormat"], ", "))
+	} else if source.LogFormat != "" && source.LogFormat != LogFormatAuto && source.isPrometheus() {
+		errs.add(path+".LogFormat", "a %s source has no event log", SourcePrometheus)
+	}
+	for _, subsystem := range logLevelOrder(source.LogLevels) {
+		if level := source.LogLevels[subsystem]; len(subsystem) == 0 || !validLogLevel(level) {
+			errs.add(path+".LogLevels."+subsystem, "invalid level: %s, expected one of %s", level, strings.Join(logLevels, ", "))
+		}
+	}
+	if len(source.LogLevels) != 0 && (source.isPrometheus() || source.isReplay()) {
+		errs.add(path+".LogLevels", "only the levels of an %s source are set", SourceEventLog)
+	}
+	if len(source.Record) != 0 && source.isPrometheus() {
+		errs.add(path+".Record", "a %s source has no event log to record", SourcePrometheus)
+	}
+	if len(source.Record) != 0 && source.isReplay() {
+		errs.add(path+".Record", "a %s source is already recorded", SourceReplay)
+	}
+	if len(source.PollInterval) != 0 && source.isReplay() {
+		errs.add(path+".PollInterval", "a %s source has no node to poll", SourceReplay)
+	}
+	if _, err := parseReplaySpeed(source.Speed); err != nil {
+		errs.add(path+".Speed", "%v", err)
+	}
+	if (len(source.File) != 0 || len(source.Speed) != 0 || source.RewriteTime) && !source.isReplay() {
+		errs.add(path+".Type", "File, Speed and RewriteTime are only used by a %s source", SourceReplay)
+	}
+	if len(source.ScrapeInterval) != 0 {
+		if interval, err := time.ParseDuration(source.ScrapeInterval); err != nil || interval <= 0 {
+			errs.add(path+".ScrapeInterval", "invalid duration: %s", source.ScrapeInterval)
+		}
+	}
+	if len(source.PollInterval) != 0 {
+		if interval, err := time.ParseDuration(source.PollInterval); err != nil || interval < 0 {
+			errs.add(path+".PollInterval", "invalid duration: %s", source.PollInterval)
+		}
+	}
+	if len(source.SpillDir) != 0 && source.Backpressure != BackpressureSpill {
+		errs.add(path+".SpillDir", "only used with the %s backpressure policy", BackpressureSpill)
+	}
+}
+
+func validSink(sink Sink, errs *ConfigErrors) {
+	//a sink without an address writes to stdout
+	if len(sink.Address) != 0 || len(sink.Port) != 0 {
+		validHostPort(sink.Address, sink.Port, "Sink", errs)
+	}
+	format := strings.ToLower(sink.Format)
+	if len(format) == 0 {
+		errs.add("Sink.Format", "required")
+	} else if !(format == "json" || format == "lineprotocol" || format == "trace") {
+		errs.add("Sink.Format", "unknown format: %s, expected one of %s", sink.Format, strings.Join(configEnums["Sink.Format"], ", "))
+	}
+	if len(sink.File) != 0 && len(sink.Address) != 0 {
+		errs.add("Sink.File", "a sink writes to a File or an Address, not both")
+	}
+	if format == "trace" && len(sink.File) == 0 {
+		errs.add("Sink.File", "required by the trace format")
+	}
+	if open, ok := sinkFileFormat(sink.File); ok && len(sink.File) != 0 && open != sink.Format {
+		errs.add("Sink.File", "already written as %s by another sink", open)
+	}
+	if sink.BatchSize < 0 {
+		errs.add("Sink.BatchSize", "must not be negative")
+	}
+	if f := sink.Flatten; f != nil {
+		if !(f.Arrays == "" || f.Arrays == FlattenJoin || f.Arrays == FlattenExplode) {
+			errs.add("Sink.Flatten.Arrays", "unknown mode: %s, expected one of %s", f.Arrays, strings.Join(configEnums["Flatten.Arrays"], ", "))
+		}
+		if format != "lineprotocol" {
+			errs.add("Sink.Flatten", "only line protocol is flattened")
+		}
+	}
+	if len(sink.Password) != 0 && len(sink.Username) == 0 {
+		errs.add("Sink.Username", "required with a password")
+	}
+}
+
+func validHostPort(address, port, path string, errs *ConfigErrors) {
+	if len(address) == 0 {
+		errs.add(path+".Address", "required")
+	} else if !validHost(address) {
+		errs.add(path+".Address", "invalid host: %s", address)
+	}
+	if len(port) == 0 {
+		errs.add(path+".Port", "required")
+	} else if !validPort(port) {
+		errs.add(path+".Port", "invalid port: %s, expected 1-65535", port)
+	}
+}
+
+var hostLabel = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?$`)
+
+//Returns true for an ip or a hostname
+func validHost(host string) bool {
+	if net.ParseIP(host) != nil {
+		return true
+	}
+	if len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if !hostLabel.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
+
+func validPort(port string) bool {
+	p, err := strconv.Atoi(port)
+	return err == nil && p > 0 && p < 65536
+}
+
+//Report keys of a config tree which are not in the schema, the paths use the schema's names
+func unknownKeys(tree interface{}, schema map[string]interface{}, path string, errs *ConfigErrors) {
+	switch v := tree.(type) {
+	case map[string]interface{}:
+		//a map, whose keys are anything
+		if values, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+			for key, value := range v {
+				unknownKeys(value, values, path+"."+key, errs)
+			}
+			return
+		}
+		props, _ := schema["properties"].(map[string]interface{})
+		var keys []string
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value := v[key]
+			name, prop := schemaProperty(props, key)
+			field := name
+			if len(path) != 0 {
+				field = path + "." + name
+			}
+			if prop == nil {
+				errs.add(field, "unknown key")
+				continue
+			}
+			unknownKeys(value, prop, field, errs)
+		}
+	case []interface{}:
+		items, _ := schema["items"].(map[string]interface{})
+		if items == nil {
+			return
+		}
+		for i, value := range v {
+			unknownKeys(value, items, fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	case []map[string]interface{}:
+		items, _ := schema["items"].(map[string]interface{})
+		if items == nil {
+			return
+		}
+		for i, value := range v {
+			unknownKeys(value, items, fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	}
+}
+
+//Find a property without case, like encoding/json matches keys to fields
+func schemaProperty(props map[string]interface{}, key string) (string, map[string]interface{}) {
+	for name, prop := range props {
+		if strings.EqualFold(name, key) {
+			p, _ := prop.(map[string]interface{})
+			return name, p
+		}
+	}
+	return key, nil
+}
+
+//Load a config file and report every problem with it, including unknown keys
+func ValidateConfigFile(path string) (*Config, error) {
+	tree, err := loadConfigTree(path, make(map[string]bool))
+	if err != nil {
+		return nil, err
+	}
+	var errs ConfigErrors
+	unknownKeys(tree, ConfigSchema(), "", &errs)
+	config, err := decodeConfigTree(tree)
+	if err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &typeErr) {
+			return nil, errors.New(fmt.Sprintf("Config %s: %v", path, err))
+		}
+		errs.add(typeErr.Field, "expected %s, got %s", typeErr.Type, typeErr.Value)
+		return nil, errs
+	}
+	if err, ok := ValidConfig(config).(ConfigErrors); ok {
+		errs = append(errs, err...)
+	}
+	return config, errs.err()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUIHandler(t *testing.T) {
+	//wired like the daemon, the ui is served without the token the control api needs
+	root := http.NewServeMux()
+	root.Handle("/ui/", uiHandler())
+	root.Handle("/", requireToken("secret", http.NotFoundHandler()))
+	server := httptest.NewServer(root)
+	defer server.Close()
+
+	tests := []struct {
+		path        string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"/ui/", http.StatusOK, "text/html", `<script src="app.js">`},
+		{"/ui/app.js", http.StatusOK, "javascript", ""},
+		{"/ui/style.css", http.StatusOK, "text/css", ""},
+		{"/ui/missing.js", http.StatusNotFound, "", ""},
+		{"/", http.StatusUnauthorized, "", ""},
+	}
+	for _, test := range tests {
+		resp, err := http.Get(server.URL + test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Error(fmt.Sprintf("Invalid Status: %s %d", test.path, resp.StatusCode))
+		}
+		if !strings.Contains(resp.Header.Get("Content-Type"), test.contentType) || !strings.Contains(string(b), test.body) {
+			t.Error(fmt.Sprintf("Invalid Content: %s %s", test.path, resp.Header.Get("Content-Type")))
+		}
+	}
+}